@@ -1,34 +1,124 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/vextasy/Timesheet_go/domain"
 	"github.com/vextasy/Timesheet_go/internal/envrc"
 	"github.com/vextasy/Timesheet_go/svc"
 )
 
 func main() {
 	env := envrc.NewEnvRc(".")
-	cfg := svc.TsConfig{}
+	cfg := svc.TsConfig{Retry: svc.DefaultRetry}
 
 	cfg.Auth.TenantId = env.Get("TenantId")
 	cfg.Auth.ClientId = env.Get("ClientId")
 	cfg.Auth.ClientSecret = env.Get("ClientSecret")
+	cfg.Auth.ClientCertificate = env.Get("ClientCertificate")
+	cfg.Auth.ClientCertificatePassword = env.Get("ClientCertificatePassword")
+	cfg.Auth.FederatedTokenFile = env.Get("FederatedTokenFile")
+	cfg.Auth.TokenCache = env.Get("TokenCache")
+	cfg.CalDAV.Username = env.Get("CalDAVUser")
+	cfg.CalDAV.Password = env.Get("CalDAVPassword")
+	cfg.CalDAV.Token = env.Get("CalDAVToken")
 
 	var nFlag = flag.Int("n", 0, "Produce a time sheet for 'n' months back.")
 	var fromFlag = flag.String("from", "", "Override 'from' date (inclusive).")
 	var toFlag = flag.String("to", "", "Override 'to' date (inclusive).")
 	flag.StringVar(&cfg.UserName, "user", env.Get("UserName"), "User name.")
+	flag.StringVar(&cfg.Auth.Mode, "auth", env.Get("AuthMode"), "Authentication mode: 'secret', 'certificate', 'workload' or 'device'. Chosen from the .envrc credentials if empty.")
+	flag.StringVar(&cfg.TimeZone, "tz", env.Get("TimeZone"), "Reporting time zone (defaults to the mailbox time zone).")
+	flag.IntVar(&cfg.Retry.MaxRetries, "retries", cfg.Retry.MaxRetries, "Number of times to retry a throttled Graph request.")
+	var calendarsFlag = flag.String("calendars", env.Get("Calendars"), "Comma separated calendars to read, each '[mailbox:]calendar'. Defaults to the user's default calendar.")
+	var excludeFlag = flag.String("exclude", env.Try("Exclude", strings.Join(svc.DefaultExclude, ",")), "Comma separated kinds of event to exclude: declined, cancelled, tentative, free, private.")
+	var subjectFlag = flag.String("subject", env.Get("SubjectPattern"), "Regular expression matching event subjects, with groups named 'project', 'group' and 'desc'.")
+	var separatorFlag = flag.String("separator", env.Get("SubjectSeparator"), "Separator between the project, group and description of event subjects, e.g. '|'.")
+	var levelsFlag = flag.String("levels", env.Get("Levels"), "Number of levels of event subjects, from the project to the description (3 by default).")
+	var categoriesFlag = flag.String("categories", env.Get("Categories"), "Comma separated Outlook categories and the project and group of their events, e.g. 'Blue category=ACME:Dev'.")
+	var precedenceFlag = flag.String("precedence", env.Get("CategoryPrecedence"), "Whether the 'subject' or the 'category' classifies an event that both could (default 'subject').")
+	var aliasesFlag = flag.String("aliases", env.Get("Aliases"), "File of rules that rename projects and groups, e.g. 'project Acme, acme/uk = ACME'.")
+	var allDayFlag = flag.String("allday", env.Get("AllDay"), "Include all-day events as this much time per working day, e.g. '7.5h'.")
+	var holidaysFlag = flag.String("holidays", env.Get("Holidays"), "Comma separated YYYY-MM-DD dates that are not working days.")
+	flag.StringVar(&cfg.CacheDir, "cache", env.Get("CacheDir"), "Directory in which to cache events between runs. Events are not cached if empty.")
+	flag.StringVar(&cfg.Record, "record", "", "Record the events read from Microsoft Graph in this fixture file.")
+	flag.StringVar(&cfg.Replay, "replay", "", "Read events from this fixture file instead of from Microsoft Graph.")
+	var icsFlag = flag.String("ics", env.Get("IcsFiles"), "Comma separated iCalendar (.ics) files to read instead of Microsoft Graph.")
+	var caldavFlag = flag.String("caldav", env.Get("CalDAVURL"), "Comma separated CalDAV calendar URLs to read instead of Microsoft Graph.")
+	var sourceFlag = flag.String("source", env.Get("Source"), "Comma separated sources of events: 'graph', 'replay', 'ics', 'caldav' or 'import'. Chosen from the other flags if empty.")
+	var duplicatesFlag = flag.String("duplicates", env.Get("Duplicates"), "How tasks found in more than one source are resolved: 'first', 'longest', 'merge', 'keep' or 'error'.")
+	var importFlag = flag.String("import", env.Get("ImportFiles"), "Comma separated CSV and JSON files of tasks to add to those read from the calendar.")
+	var csvColumnsFlag = flag.String("csvcolumns", env.Get("CsvColumns"), "Comma separated CSV headers of the task fields, e.g. 'project=Client,duration=Hours'.")
+	var tagsFlag = flag.String("tags", env.Get("Tags"), "Comma separated tags, e.g. 'billable,ticket=JIRA-123', that every reported task must have.")
+	var subtotalFlag = flag.String("subtotal", env.Get("SubtotalTags"), "Comma separated names of tags whose time is subtotalled within each project, e.g. 'billable,ticket'.")
+	var maxUnclassifiedFlag = flag.String("maxunclassified", env.Get("MaxUnclassified"), "Fail if more than this much time, e.g. '30m', is spent in events whose subjects do not match.")
+	var timeoutFlag = flag.Duration("timeout", 5*time.Minute, "Give up if the report is not complete within this time.")
+	flag.BoolVar(&cfg.Verbose, "v", false, "Report progress information on stderr.")
 	flag.Parse()
+	cfg.Auth.Mode = strings.ToLower(strings.TrimSpace(cfg.Auth.Mode))
+	cfg.Calendars = svc.ParseCalendarRefs(*calendarsFlag)
+	var err error
+	if cfg.Exclude, err = svc.ParseExclusions(*excludeFlag); err != nil {
+		exit(err)
+	}
+	levels := 0
+	if len(*levelsFlag) > 0 {
+		if levels, err = strconv.Atoi(*levelsFlag); err != nil {
+			fail("bad format 'levels' flag.")
+		}
+	}
+	if cfg.Subject, err = svc.ParseSubject(*subjectFlag, *separatorFlag, levels); err != nil {
+		exit(err)
+	}
+	if cfg.Categories, err = svc.ParseCategoryMap(*categoriesFlag); err != nil {
+		exit(err)
+	}
+	if cfg.CategoryPrecedence, err = svc.ParsePrecedence(*precedenceFlag); err != nil {
+		exit(err)
+	}
+	if cfg.Aliases, err = svc.LoadAliases(*aliasesFlag); err != nil {
+		exit(err)
+	}
+	if len(*allDayFlag) > 0 {
+		cfg.AllDay, err = time.ParseDuration(*allDayFlag)
+		if err != nil || cfg.AllDay < 0 {
+			fail("bad format 'allday' flag.")
+		}
+	}
+	if len(*maxUnclassifiedFlag) > 0 {
+		cfg.MaxUnclassified, err = time.ParseDuration(*maxUnclassifiedFlag)
+		if err != nil || cfg.MaxUnclassified < 0 {
+			fail("bad format 'maxunclassified' flag.")
+		}
+	}
+	if cfg.Holidays, err = svc.ParseHolidays(*holidaysFlag); err != nil {
+		exit(err)
+	}
+	cfg.IcsFiles = splitList(*icsFlag)
+	cfg.CalDAV.URLs = splitList(*caldavFlag)
+	cfg.ImportFiles = splitList(*importFlag)
+	cfg.Sources = splitList(*sourceFlag)
+	cfg.Tags = svc.ParseTags(*tagsFlag)
+	cfg.SubtotalTags = svc.ParseTags(*subtotalFlag)
+	if cfg.Duplicates, err = svc.ParseDuplicates(*duplicatesFlag); err != nil {
+		exit(err)
+	}
+	if cfg.CsvColumns, err = svc.ParseCsvColumns(*csvColumnsFlag); err != nil {
+		exit(err)
+	}
 
 	// Determine the date range from the -n flag (or its default).
 	cfg.DateFrom, cfg.DateTo = monthOffset(time.Now(), *nFlag)
 
 	// Allow the -from and -to flags to override the -n flag.
-	var err error
 	if len(*fromFlag) > 0 {
 		cfg.DateFrom, err = time.ParseInLocation("2006-01-02", *fromFlag, time.Local)
 		if err != nil {
@@ -44,8 +134,19 @@ func main() {
 		cfg.DateTo = asToDate(cfg.DateTo)
 	}
 
-	tsSvc := svc.NewTimesheetSvc(cfg, svc.NewServices(cfg))
-	tsSvc.Run()
+	services, err := svc.NewServices(cfg)
+	if err != nil {
+		exit(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	tsSvc := svc.NewTimesheetSvc(cfg, services)
+	if err := tsSvc.Run(ctx); err != nil {
+		exit(err)
+	}
 }
 
 // Month_offset returns the start and end date of the month
@@ -77,8 +178,46 @@ func asToDate(d time.Time) time.Time {
 	return time.Date(d.Year(), d.Month(), d.Day(), 23, 59, 59, 0, d.Location())
 }
 
+// Exit codes for each category of failure.
+// The flag package already uses 2 for usage errors.
+const (
+	exitFailure = 1
+	exitUsage   = 2
+	exitConfig  = 3
+	exitAuth    = 4
+	exitNetwork = 5
+	exitParse   = 6
+)
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	switch {
+	case errors.Is(err, domain.ErrConfig):
+		os.Exit(exitConfig)
+	case errors.Is(err, domain.ErrAuth):
+		os.Exit(exitAuth)
+	case errors.Is(err, domain.ErrNetwork):
+		os.Exit(exitNetwork)
+	case errors.Is(err, domain.ErrParse):
+		os.Exit(exitParse)
+	default:
+		os.Exit(exitFailure)
+	}
+}
+
 func fail(msg string) {
 	fmt.Println(msg)
 	flag.PrintDefaults()
-	os.Exit(2)
+	os.Exit(exitUsage)
+}
+
+// Split a comma separated list, ignoring empty entries.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
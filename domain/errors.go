@@ -0,0 +1,13 @@
+package domain
+
+import "errors"
+
+// Categories of failure.
+// Services wrap their errors with one of these so that
+// the command line can exit with a distinct code for each.
+var (
+	ErrConfig  = errors.New("configuration error")
+	ErrAuth    = errors.New("authentication error")
+	ErrNetwork = errors.New("network error")
+	ErrParse   = errors.New("parse error")
+)
@@ -2,31 +2,185 @@ package domain
 
 import (
 	"slices"
+	"strings"
 	"time"
 )
 
 type Project struct {
 	Name    string
 	Tasks   []Task
-	Summary map[string]*TaskSummary
-	Groups  map[string]*GroupSummary
+	Summary map[TaskKey]*TaskSummary
+	Groups  map[string]*GroupSummary // By group.
+	Tree    *SummaryNode             // The summary of every level of the task hierarchy.
+	Tags    map[TagKey]*TagSummary
+}
+
+// A TaskKey identifies the tasks of a Project that have the same path:
+// the same group, subgroups and description.
+type TaskKey struct {
+	Group     string
+	Subgroups string // The subgroups joined by SubgroupSeparator.
+	Desc      string
+}
+
+// The separator of the subgroups of a TaskKey, which cannot be part of a name.
+const SubgroupSeparator = "\x00"
+
+// Return the key of the summary of a task.
+func (t Task) Key() TaskKey {
+	return TaskKey{Group: t.Group, Subgroups: strings.Join(t.Subgroups, SubgroupSeparator), Desc: t.Desc}
+}
+
+// A TagKey identifies the tasks of a Project that have the same value of a tag.
+type TagKey struct {
+	Name  string
+	Value string
 }
 
 // A Task represents an Outlook event that has
 // the format "Project - Group - Description".
+// Deeper hierarchies, such as "Client - Project - Workstream - Task",
+// hold the levels between the Group and the Description in Subgroups.
+// An event whose subject has some other format is an Unclassified
+// task that has no Project and whose Desc is the whole subject.
 type Task struct {
-	Project  string
-	Group    string
-	Desc     string
-	Start    time.Time
+	Project   string
+	Group     string
+	Subgroups []string // Outermost first.
+	Desc      string
+	Start     time.Time
+	Duration  time.Duration
+	Calendar  string // The calendar from which the task was read.
+	Source    string // The source from which the task was read, such as "graph".
+
+	// Annotations such as "#billable" and "#ticket=JIRA-123", which map
+	// the tag name to its value, or to "" if it has none.
+	Tags map[string]string
+
+	Unclassified bool // The subject did not have the expected format.
+}
+
+// Return the time at which the task ends.
+func (t Task) End() time.Time {
+	return t.Start.Add(t.Duration)
+}
+
+// Return the part of the task that falls within the period from, up to but
+// not including to. The boolean result is false if no part of it does.
+func (t Task) Clip(from time.Time, to time.Time) (Task, bool) {
+	start, end := t.Start, t.End()
+	if start.Before(from) {
+		start = from.In(t.Start.Location())
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !start.Before(end) {
+		return Task{}, false
+	}
+	t.Start = start
+	t.Duration = end.Sub(start)
+	return t, true
+}
+
+// Split the task at each midnight, in the location of its start time, so
+// that each part falls within a single calendar day.
+func (t Task) SplitDays() []Task {
+	parts := []Task{}
+	end := t.End()
+	for {
+		y, m, d := t.Start.Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, t.Start.Location())
+		if !midnight.Before(end) {
+			return append(parts, t)
+		}
+		part := t
+		part.Duration = midnight.Sub(t.Start)
+		parts = append(parts, part)
+		t.Start = midnight
+		t.Duration = end.Sub(midnight)
+	}
+}
+
+// Return the levels of the task within its project: its group, any
+// subgroups and its description.
+func (t Task) Path() []string {
+	return append(append([]string{t.Group}, t.Subgroups...), t.Desc)
+}
+
+// Report whether the task has a tag, which is written "name" to match any
+// value of the tag or "name=value" to match only the one value.
+func (t Task) HasTag(tag string) bool {
+	name, value, hasValue := strings.Cut(strings.TrimPrefix(tag, "#"), "=")
+	v, ok := t.Tags[strings.ToLower(name)]
+	return ok && (!hasValue || v == value)
+}
+
+// Return the text of a tag as it is written in an event subject.
+func FormatTag(name string, value string) string {
+	if value == "" {
+		return "#" + name
+	}
+	return "#" + name + "=" + value
+}
+
+// A SummaryNode is a summary of all tasks at one level of the task
+// hierarchy: the project at the root, then each group and subgroup,
+// and each description at the leaves.
+type SummaryNode struct {
+	Name     string
 	Duration time.Duration
+	Started  time.Time      // earliest task start
+	Children []*SummaryNode // in order of their earliest task start
+}
+
+// Add a task at the given path below the node and increment the durations.
+func (node *SummaryNode) add(path []string, t Task) {
+	node.Duration += t.Duration
+	if node.Started.IsZero() || node.Started.After(t.Start) {
+		node.Started = t.Start
+	}
+	if len(path) == 0 {
+		return
+	}
+	var child *SummaryNode
+	for _, c := range node.Children {
+		if c.Name == path[0] {
+			child = c
+			break
+		}
+	}
+	if child == nil {
+		child = &SummaryNode{Name: path[0]}
+		node.Children = append(node.Children, child)
+	}
+	child.add(path[1:], t)
+}
+
+// Return the number of levels of the tree below the node.
+func (node *SummaryNode) Depth() int {
+	depth := 0
+	for _, c := range node.Children {
+		depth = max(depth, 1+c.Depth())
+	}
+	return depth
 }
 
 // Within a Project a TaskSummary is a summary of all tasks
-// that have the same group and description.
+// that have the same group, subgroups and description.
 type TaskSummary struct {
-	Group    string
-	Desc     string
+	Group     string
+	Subgroups []string
+	Desc      string
+	Duration  time.Duration
+	Started   time.Time // earliest task start
+}
+
+// Within a Project a TagSummary is a summary of all tasks
+// that have the same value of a tag.
+type TagSummary struct {
+	Name     string
+	Value    string
 	Duration time.Duration
 	Started  time.Time // earliest task start
 }
@@ -43,8 +197,10 @@ func NewProject(name string) *Project {
 	return &Project{
 		Name:    name,
 		Tasks:   []Task{},
-		Summary: map[string]*TaskSummary{},
+		Summary: map[TaskKey]*TaskSummary{},
 		Groups:  map[string]*GroupSummary{},
+		Tree:    &SummaryNode{Name: name},
+		Tags:    map[TagKey]*TagSummary{},
 	}
 }
 
@@ -52,11 +208,12 @@ func (proj *Project) AddTask(task Task) {
 	proj.Tasks = append(proj.Tasks, task)
 }
 
-func newTaskSummary(desc string, group string) *TaskSummary {
+func newTaskSummary(desc string, group string, subgroups []string) *TaskSummary {
 	return &TaskSummary{
-		Group:    group,
-		Desc:     desc,
-		Duration: 0,
+		Group:     group,
+		Subgroups: subgroups,
+		Desc:      desc,
+		Duration:  0,
 	}
 }
 
@@ -70,10 +227,10 @@ func newGroupSummary(group string) *GroupSummary {
 // Add the task to the appropriate project summary and groups
 // and increment their durations.
 func (proj *Project) sum(t Task) {
-	// Project Summary is desc within group.
-	key := t.Group + "-" + t.Desc
+	// Project Summary is desc within subgroups within group.
+	key := t.Key()
 	if _, ok := proj.Summary[key]; !ok {
-		proj.Summary[key] = newTaskSummary(t.Desc, t.Group)
+		proj.Summary[key] = newTaskSummary(t.Desc, t.Group, t.Subgroups)
 	}
 	s := proj.Summary[key]
 	s.Duration += t.Duration
@@ -82,15 +239,27 @@ func (proj *Project) sum(t Task) {
 	}
 
 	// Project Group is just group.
-	key = t.Group
-	if _, ok := proj.Groups[key]; !ok {
-		proj.Groups[key] = newGroupSummary(t.Group)
+	if _, ok := proj.Groups[t.Group]; !ok {
+		proj.Groups[t.Group] = newGroupSummary(t.Group)
 	}
-	g := proj.Groups[key]
+	g := proj.Groups[t.Group]
 	g.Duration += t.Duration
 	if g.Started.IsZero() || g.Started.After(t.Start) {
 		g.Started = t.Start
 	}
+
+	// Project Tags are each value of each tag.
+	for name, value := range t.Tags {
+		key := TagKey{Name: name, Value: value}
+		if _, ok := proj.Tags[key]; !ok {
+			proj.Tags[key] = &TagSummary{Name: name, Value: value}
+		}
+		ts := proj.Tags[key]
+		ts.Duration += t.Duration
+		if ts.Started.IsZero() || ts.Started.After(t.Start) {
+			ts.Started = t.Start
+		}
+	}
 }
 
 // Arrange tasks in start order and summarize.
@@ -100,5 +269,6 @@ func (proj *Project) Summarize() {
 	})
 	for _, task := range proj.Tasks {
 		proj.sum(task)
+		proj.Tree.add(task.Path(), task)
 	}
 }
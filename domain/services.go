@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type TimesheetServices struct {
 	// the interfaces used by Timesheet
@@ -9,11 +12,11 @@ type TimesheetServices struct {
 	Dump  DumpSvc     // Dump tasks to stdout.
 }
 type TimesheetSvc interface {
-	Run() error
+	Run(ctx context.Context) error
 }
 
 type GraphSvc interface {
-	Read(userName string, fromDate time.Time, toDate time.Time) ([]Task, error)
+	Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]Task, error)
 }
 
 type CalendarSvc interface {
@@ -21,15 +24,42 @@ type CalendarSvc interface {
 }
 
 type DumpSvc interface {
-	Projects(projects []*Project) []string
+	Projects(projects []*Project, unclassified []Task) []string
 }
 
 // Credentials required to construct an identity provider.
+//   - "AuthMode": "<secret, certificate, workload or device>",
 //   - "TenantId": "<the azure tenant id>",
 //   - "ClientId": "<the app registration application (client) id>",
 //   - "ClientSecret": "<the azure client secret>"
+//   - "ClientCertificate": "<the path of a PEM or PFX client certificate>"
+//   - "ClientCertificatePassword": "<the password of the client certificate, if any>"
+//   - "FederatedTokenFile": "<the path of a workload identity federated token>"
+//   - "TokenCache": "<the file in which delegated tokens are cached>"
+//
+// When AuthMode is empty the mode is chosen by which one of
+// ClientSecret, ClientCertificate or FederatedTokenFile is present.
 type Auth struct {
-	TenantId     string
-	ClientId     string
-	ClientSecret string
+	Mode                      string
+	TenantId                  string
+	ClientId                  string
+	ClientSecret              string
+	ClientCertificate         string
+	ClientCertificatePassword string
+	FederatedTokenFile        string
+	TokenCache                string
+}
+
+// Authentication modes.
+const (
+	AuthSecret      = "secret"      // Application permissions using a client secret.
+	AuthCertificate = "certificate" // Application permissions using a client certificate.
+	AuthWorkload    = "workload"    // Application permissions using a workload identity federated token.
+	AuthDevice      = "device"      // Delegated permissions for the signed in user using the device code flow.
+)
+
+// Report whether the credentials act on behalf of a signed in user
+// rather than on behalf of the application.
+func (auth Auth) Delegated() bool {
+	return auth.Mode == AuthDevice
 }
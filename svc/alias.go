@@ -0,0 +1,211 @@
+package svc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// The levels of a task whose names may be rewritten.
+const (
+	AliasProject = "project"
+	AliasGroup   = "group"
+)
+
+// An AliasRule rewrites the project or group names that it matches.
+type AliasRule struct {
+	Level   string         // AliasProject or AliasGroup.
+	Names   []string       // The names that are rewritten, if there is no Pattern.
+	Pattern *regexp.Regexp // The pattern matching the names that are rewritten.
+	To      string         // The new name, which may refer to the groups of the Pattern as $1.
+}
+
+// Aliases normalise the project and group names of tasks so that tasks
+// recorded under different spellings are reported together.
+type Aliases struct {
+	Rules    []AliasRule
+	FoldCase bool // Names that differ only in case are spelled as in the earliest task.
+}
+
+// A Rewrite records a name that was changed by the aliases.
+type Rewrite struct {
+	Level string
+	From  string
+	To    string
+	Tasks int // The number of tasks whose name was changed.
+}
+
+// Load the aliases in a file. Each line holds a rule, a comment starting
+// with "#" or is blank. A rule is one of
+//
+//	foldcase
+//	project Acme, acme/uk = ACME
+//	group /^dev(elopment)?$/i = Dev
+//
+// which respectively merge names that differ only in case, rename the listed
+// names and rewrite the names matching a regular expression, with optional
+// "i" flag, to the replacement. The first rule matching a name is applied.
+func LoadAliases(path string) (Aliases, error) {
+	if path == "" {
+		return Aliases{}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return Aliases{}, fmt.Errorf("%w: %w", domain.ErrConfig, err)
+	}
+	defer f.Close()
+	aliases, err := readAliases(f)
+	if err != nil {
+		return Aliases{}, fmt.Errorf("%w: %s: %w", domain.ErrConfig, path, err)
+	}
+	return aliases, nil
+}
+
+var aliasPatternRule = regexp.MustCompile(`^/(.*)/(i?)$`)
+
+// Read the aliases of a file. Errors are prefixed with their line number.
+func readAliases(r io.Reader) (Aliases, error) {
+	aliases := Aliases{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.EqualFold(text, "foldcase") {
+			aliases.FoldCase = true
+			continue
+		}
+		level, rest, _ := strings.Cut(text, " ")
+		from, to, ok := strings.Cut(rest, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		level = strings.ToLower(level)
+		if (level != AliasProject && level != AliasGroup) || !ok || from == "" {
+			return Aliases{}, fmt.Errorf("%d: expected 'foldcase' or '%s|%s names = name'", line, AliasProject, AliasGroup)
+		}
+		if level == AliasProject && to == "" {
+			return Aliases{}, fmt.Errorf("%d: a project cannot be renamed to nothing", line)
+		}
+		rule := AliasRule{Level: level, To: to}
+		if m := aliasPatternRule.FindStringSubmatch(from); m != nil {
+			expr := m[1]
+			if m[2] == "i" {
+				expr = "(?i)" + expr
+			}
+			var err error
+			if rule.Pattern, err = regexp.Compile(expr); err != nil {
+				return Aliases{}, fmt.Errorf("%d: %w", line, err)
+			}
+		} else {
+			for _, name := range strings.Split(from, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					rule.Names = append(rule.Names, name)
+				}
+			}
+		}
+		aliases.Rules = append(aliases.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return Aliases{}, fmt.Errorf("%d: %w", line+1, err)
+	}
+	return aliases, nil
+}
+
+// Return the name given to a project or group name by the first rule of the
+// level that matches it, or the name itself if none does.
+func (aliases Aliases) rename(level string, name string) string {
+	for _, rule := range aliases.Rules {
+		if rule.Level != level {
+			continue
+		}
+		if rule.Pattern == nil {
+			if slices.Contains(rule.Names, name) {
+				return rule.To
+			}
+			continue
+		}
+		if m := rule.Pattern.FindStringSubmatchIndex(name); m != nil {
+			return string(rule.Pattern.ExpandString(nil, rule.To, name, m))
+		}
+	}
+	return name
+}
+
+// Apply the aliases to the projects and groups of the tasks, returning the
+// rewritten tasks and the names that were changed, ordered by level and name.
+// Unclassified tasks are left as they are.
+func (aliases Aliases) Apply(tasks []domain.Task) ([]domain.Task, []Rewrite) {
+	if len(aliases.Rules) == 0 && !aliases.FoldCase {
+		return tasks, nil
+	}
+	renamed := make([]domain.Task, len(tasks))
+	for i, task := range tasks {
+		if !task.Unclassified {
+			task.Project = aliases.rename(AliasProject, task.Project)
+			task.Group = aliases.rename(AliasGroup, task.Group)
+		}
+		renamed[i] = task
+	}
+	if aliases.FoldCase {
+		foldCase(renamed)
+	}
+
+	counts := map[Rewrite]int{}
+	for i, task := range renamed {
+		if task.Project != tasks[i].Project {
+			counts[Rewrite{Level: AliasProject, From: tasks[i].Project, To: task.Project}]++
+		}
+		if task.Group != tasks[i].Group {
+			counts[Rewrite{Level: AliasGroup, From: tasks[i].Group, To: task.Group}]++
+		}
+	}
+	rewrites := []Rewrite{}
+	for r, n := range counts {
+		r.Tasks = n
+		rewrites = append(rewrites, r)
+	}
+	slices.SortFunc(rewrites, func(a, b Rewrite) int {
+		return strings.Compare(a.Level+"\x00"+a.From+"\x00"+a.To, b.Level+"\x00"+b.From+"\x00"+b.To)
+	})
+	return renamed, rewrites
+}
+
+// Spell the projects, and the groups within each project, that differ only
+// in case as they are spelled in the earliest task.
+func foldCase(tasks []domain.Task) {
+	type spelling struct {
+		name  string
+		start time.Time
+	}
+	earliest := map[string]spelling{}
+	choose := func(key string, name string, start time.Time) {
+		if s, ok := earliest[key]; !ok || start.Before(s.start) {
+			earliest[key] = spelling{name, start}
+		}
+	}
+	for _, t := range tasks {
+		if !t.Unclassified {
+			choose("p\x00"+strings.ToLower(t.Project), t.Project, t.Start)
+		}
+	}
+	for i, t := range tasks {
+		if !t.Unclassified {
+			tasks[i].Project = earliest["p\x00"+strings.ToLower(t.Project)].name
+			choose("g\x00"+strings.ToLower(tasks[i].Project)+"\x00"+strings.ToLower(t.Group), t.Group, t.Start)
+		}
+	}
+	for i, t := range tasks {
+		if !t.Unclassified {
+			tasks[i].Group = earliest["g\x00"+strings.ToLower(t.Project)+"\x00"+strings.ToLower(t.Group)].name
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package svc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+func Test_read_aliases(t *testing.T) {
+	aliases, err := readAliases(strings.NewReader(`
+# Customers
+foldcase
+project Acme, acme/uk = ACME
+group /^dev(elopment)?$/i = Dev
+group Misc =
+`))
+	assert.NoError(t, err)
+	assert.True(t, aliases.FoldCase)
+	assert.Equal(t, 3, len(aliases.Rules))
+	assert.Equal(t, []string{"Acme", "acme/uk"}, aliases.Rules[0].Names)
+	assert.Equal(t, "Dev", aliases.Rules[1].To)
+	assert.Equal(t, "", aliases.Rules[2].To)
+
+	for text, want := range map[string]string{
+		"\nclient Acme = ACME":      "2: expected",
+		"project Acme = ":           "1: a project cannot be renamed to nothing",
+		"group /dev(/ = Dev":        "1: error parsing regexp",
+		"project Acme\nfoldcase ok": "1: expected",
+	} {
+		_, err := readAliases(strings.NewReader(text))
+		assert.ErrorContains(t, err, want, text)
+	}
+
+	_, err = LoadAliases(writeFile(t, "aliases.txt", "project = ACME"))
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	assert.ErrorContains(t, err, "aliases.txt: 1: expected")
+}
+
+// Renames projects and groups and reports the names that were changed.
+func Test_apply_aliases(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 11, d, 9, 0, 0, 0, time.UTC) }
+	tasks := []domain.Task{
+		{Project: "Acme", Group: "development", Desc: "a", Start: day(2)},
+		{Project: "acme/uk", Group: "Dev", Desc: "b", Start: day(3)},
+		{Project: "ProjectX", Group: "Doc", Desc: "c", Start: day(4)},
+		{Project: "projectx", Group: "doc", Desc: "d", Start: day(1)},
+		{Desc: "Acme", Start: day(5), Unclassified: true},
+	}
+	aliases, err := readAliases(strings.NewReader("foldcase\nproject Acme, acme/uk = ACME\ngroup /^dev(elopment)?$/i = Dev\n"))
+	assert.NoError(t, err)
+	renamed, rewrites := aliases.Apply(tasks)
+	assert.Equal(t, "Acme", tasks[0].Project) // The tasks read are unchanged.
+	names := []string{}
+	for _, task := range renamed {
+		names = append(names, task.Project+"/"+task.Group)
+	}
+	assert.Equal(t, []string{"ACME/Dev", "ACME/Dev", "projectx/doc", "projectx/doc", "/"}, names)
+	assert.Equal(t, []Rewrite{
+		{Level: AliasGroup, From: "Doc", To: "doc", Tasks: 1},
+		{Level: AliasGroup, From: "development", To: "Dev", Tasks: 1},
+		{Level: AliasProject, From: "Acme", To: "ACME", Tasks: 1},
+		{Level: AliasProject, From: "ProjectX", To: "projectx", Tasks: 1},
+		{Level: AliasProject, From: "acme/uk", To: "ACME", Tasks: 1},
+	}, rewrites)
+
+	// A pattern's replacement may refer to its groups.
+	aliases, _ = readAliases(strings.NewReader(`project /^(\w+)/uk$/ = $1`))
+	renamed, _ = aliases.Apply(tasks)
+	assert.Equal(t, "acme", renamed[1].Project)
+
+	renamed, rewrites = Aliases{}.Apply(tasks)
+	assert.Equal(t, tasks, renamed)
+	assert.Nil(t, rewrites)
+}
@@ -0,0 +1,84 @@
+package svc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// Parse a comma separated list of holiday dates in the YYYY-MM-DD format.
+func ParseHolidays(s string) ([]time.Time, error) {
+	holidays := []time.Time{}
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", d)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad holiday date '%s'", domain.ErrConfig, d)
+		}
+		holidays = append(holidays, day)
+	}
+	return holidays, nil
+}
+
+func isAllDay(ev models.Eventable) bool {
+	return ev.GetIsAllDay() != nil && *ev.GetIsAllDay()
+}
+
+// Convert an all-day (or multi-day) Graph event into one task for each working day
+// that it covers within the date range.
+// The task argument provides the classification of the event.
+func (svc graphSvc) allDayTasks(ev models.Eventable, task domain.Task, fromDate time.Time, toDate time.Time, loc *time.Location) ([]domain.Task, error) {
+	// All-day events run from midnight to midnight in their own time zone
+	// and so it is their wall clock dates that matter.
+	start, err := eventTime(ev.GetStart())
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse start time: %v", domain.ErrParse, err)
+	}
+	end, err := eventTime(ev.GetEnd())
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse end time: %v", domain.ErrParse, err)
+	}
+	return workingDayTasks(svc.cfg, task, start, end, fromDate, toDate, loc), nil
+}
+
+// Return a copy of task for each working day from the date of start up to,
+// but not including, the date of end that falls within the date range.
+// Each task starts at midnight in the location loc and lasts for the
+// configured all-day duration.
+func workingDayTasks(cfg TsConfig, task domain.Task, start time.Time, end time.Time, fromDate time.Time, toDate time.Time, loc *time.Location) []domain.Task {
+	tasks := []domain.Task{}
+	for d := dateOf(start, loc); d.Before(dateOf(end, loc)); d = d.AddDate(0, 0, 1) {
+		if d.Before(dateOf(fromDate, loc)) || d.After(toDate) || !workingDay(cfg, d) {
+			continue
+		}
+		t := task
+		t.Start = d
+		t.Duration = cfg.AllDay
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// Return midnight in the location loc on the wall clock date of t.
+func dateOf(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// Report whether the day is neither a weekend nor a holiday.
+func workingDay(cfg TsConfig, d time.Time) bool {
+	if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+		return false
+	}
+	for _, h := range cfg.Holidays {
+		if h.Year() == d.Year() && h.Month() == d.Month() && h.Day() == d.Day() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package svc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func allDayEvent(subject string, from time.Time, to time.Time) map[string]any {
+	ev := graphEvent(subject, from, to)
+	ev["isAllDay"] = true
+	return ev
+}
+
+// Converts each working day of an all-day event into a task of the configured length.
+func Test_read_all_day_events(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 11, d, 0, 0, 0, 0, time.UTC) }
+	fg := newFakeGraph(t, []map[string]any{
+		// Friday to Tuesday, over a weekend and a holiday.
+		allDayEvent("ACME - Training - course", day(24), day(29)),
+		// Starts before the report range.
+		allDayEvent("ACME - Support - on call", day(1).AddDate(0, 0, -2), day(2)),
+		graphEvent("ACME - Support - standup", day(6).Add(9*hr), day(6).Add(9*hr+15*min)),
+	})
+	holidays, err := ParseHolidays("2023-11-27")
+	assert.NoError(t, err)
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{TimeZone: "UTC", AllDay: 7*hr + 30*min, Holidays: holidays})
+
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, "", fg.queries[0].Get("$filter"))
+	starts := []string{}
+	for _, task := range tasks {
+		starts = append(starts, task.Start.Format(time.RFC3339))
+		if task.Group != "Support" || task.Desc != "standup" {
+			assert.Equal(t, 7*hr+30*min, task.Duration)
+		}
+	}
+	assert.Equal(t, []string{"2023-11-24T00:00:00Z", "2023-11-28T00:00:00Z", "2023-11-01T00:00:00Z", "2023-11-06T09:00:00Z"}, starts)
+}
+
+// All-day events are filtered out by Graph unless they have been asked for.
+func Test_read_ignores_all_day_events_by_default(t *testing.T) {
+	fg := newFakeGraph(t, []map[string]any{})
+	_, err := newTestGraphSvc(t, fg.URL, TsConfig{}).Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, "isAllDay eq false", fg.queries[0].Get("$filter"))
+}
+
+func Test_parse_holidays(t *testing.T) {
+	holidays, err := ParseHolidays("2023-12-25, 2023-12-26,")
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Time{time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), time.Date(2023, 12, 26, 0, 0, 0, 0, time.UTC)}, holidays)
+	_, err = ParseHolidays("25/12/2023")
+	assert.Error(t, err)
+}
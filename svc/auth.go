@@ -0,0 +1,197 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azidentity "github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/public"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// The scopes requested for application permissions.
+var applicationScopes = []string{"https://graph.microsoft.com/.default"}
+
+// The scopes requested for delegated permissions.
+var delegatedScopes = []string{
+	"https://graph.microsoft.com/Calendars.Read",
+	"https://graph.microsoft.com/Calendars.Read.Shared",
+	"https://graph.microsoft.com/MailboxSettings.Read",
+}
+
+// Construct the credential and scopes for the configured authentication mode.
+func newCredential(auth domain.Auth) (azcore.TokenCredential, []string, error) {
+	mode, err := authMode(auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mode != domain.AuthDevice && (auth.TenantId == "" || auth.ClientId == "") {
+		return nil, nil, fmt.Errorf("%w: TenantId and ClientId are required", domain.ErrConfig)
+	}
+	switch mode {
+	case domain.AuthSecret:
+		if auth.ClientSecret == "" {
+			return nil, nil, fmt.Errorf("%w: ClientSecret is required", domain.ErrConfig)
+		}
+		cred, err := azidentity.NewClientSecretCredential(auth.TenantId, auth.ClientId, auth.ClientSecret, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: creating credentials: %w", domain.ErrAuth, err)
+		}
+		return cred, applicationScopes, nil
+	case domain.AuthCertificate:
+		data, err := os.ReadFile(auth.ClientCertificate)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: reading client certificate: %w", domain.ErrConfig, err)
+		}
+		// ParseCertificates accepts both PEM and PFX (PKCS#12) data.
+		var password []byte
+		if auth.ClientCertificatePassword != "" {
+			password = []byte(auth.ClientCertificatePassword)
+		}
+		certs, key, err := azidentity.ParseCertificates(data, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: parsing client certificate: %w", domain.ErrConfig, err)
+		}
+		cred, err := azidentity.NewClientCertificateCredential(auth.TenantId, auth.ClientId, certs, key, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: creating credentials: %w", domain.ErrAuth, err)
+		}
+		return cred, applicationScopes, nil
+	case domain.AuthWorkload:
+		cred, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			TenantID:      auth.TenantId,
+			ClientID:      auth.ClientId,
+			TokenFilePath: auth.FederatedTokenFile,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: creating credentials: %w", domain.ErrAuth, err)
+		}
+		return cred, applicationScopes, nil
+	case domain.AuthDevice:
+		cred, err := newDeviceCodeCredential(auth)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cred, delegatedScopes, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown authentication mode '%s'", domain.ErrConfig, auth.Mode)
+	}
+}
+
+// Determine the authentication mode.
+// An explicit mode is used whatever its case. Otherwise the mode is chosen by which
+// one of the client secret, client certificate or federated token file is present.
+func authMode(auth domain.Auth) (string, error) {
+	if auth.Mode != "" {
+		return strings.ToLower(auth.Mode), nil
+	}
+	present := []string{}
+	if auth.ClientSecret != "" {
+		present = append(present, domain.AuthSecret)
+	}
+	if auth.ClientCertificate != "" {
+		present = append(present, domain.AuthCertificate)
+	}
+	if auth.FederatedTokenFile != "" {
+		present = append(present, domain.AuthWorkload)
+	}
+	switch len(present) {
+	case 0:
+		return "", fmt.Errorf("%w: one of ClientSecret, ClientCertificate or FederatedTokenFile is required", domain.ErrConfig)
+	case 1:
+		return present[0], nil
+	default:
+		return "", fmt.Errorf("%w: ambiguous credentials: ClientSecret, ClientCertificate and FederatedTokenFile are alternatives; found %s (set AuthMode to choose one)",
+			domain.ErrConfig, strings.Join(present, ", "))
+	}
+}
+
+// deviceCodeCredential implements azcore.TokenCredential using the device code flow.
+// Tokens are held in a file cache so that the user is only prompted to sign in
+// when there is no cached account or its refresh token has expired.
+type deviceCodeCredential struct {
+	client public.Client
+}
+
+func newDeviceCodeCredential(auth domain.Auth) (deviceCodeCredential, error) {
+	if auth.ClientId == "" {
+		return deviceCodeCredential{}, fmt.Errorf("%w: ClientId is required", domain.ErrConfig)
+	}
+	tenant := auth.TenantId
+	if tenant == "" {
+		tenant = "organizations"
+	}
+	path := auth.TokenCache
+	if path == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return deviceCodeCredential{}, fmt.Errorf("%w: no TokenCache given and no user cache directory: %w", domain.ErrConfig, err)
+		}
+		path = filepath.Join(dir, "timesheet", "token_cache.json")
+	}
+	client, err := public.New(auth.ClientId,
+		public.WithAuthority("https://login.microsoftonline.com/"+tenant),
+		public.WithCache(tokenCache{path}),
+	)
+	if err != nil {
+		return deviceCodeCredential{}, fmt.Errorf("%w: creating public client: %w", domain.ErrAuth, err)
+	}
+	return deviceCodeCredential{client}, nil
+}
+
+func (cred deviceCodeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	// Try the cache first. MSAL will use a cached refresh token if the access token has expired.
+	accounts, err := cred.client.Accounts(ctx)
+	if err == nil && len(accounts) > 0 {
+		res, err := cred.client.AcquireTokenSilent(ctx, opts.Scopes, public.WithSilentAccount(accounts[0]))
+		if err == nil {
+			return azcore.AccessToken{Token: res.AccessToken, ExpiresOn: res.ExpiresOn}, nil
+		}
+	}
+
+	// Otherwise ask the user to sign in.
+	dc, err := cred.client.AcquireTokenByDeviceCode(ctx, opts.Scopes)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%w: %w", domain.ErrAuth, err)
+	}
+	fmt.Fprintln(os.Stderr, dc.Result.Message)
+	res, err := dc.AuthenticationResult(ctx)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%w: %w", domain.ErrAuth, err)
+	}
+	return azcore.AccessToken{Token: res.AccessToken, ExpiresOn: res.ExpiresOn}, nil
+}
+
+// tokenCache implements cache.ExportReplace by keeping the MSAL cache in a file.
+// The file holds refresh tokens and so is only readable by its owner.
+type tokenCache struct {
+	path string
+}
+
+func (c tokenCache) Replace(ctx context.Context, u cache.Unmarshaler, hints cache.ReplaceHints) error {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return u.Unmarshal(data)
+}
+
+func (c tokenCache) Export(ctx context.Context, m cache.Marshaler, hints cache.ExportHints) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0600)
+}
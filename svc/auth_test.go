@@ -0,0 +1,111 @@
+package svc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+type bytesCache struct{ data []byte }
+
+func (c *bytesCache) Marshal() ([]byte, error) { return c.data, nil }
+func (c *bytesCache) Unmarshal(b []byte) error { c.data = b; return nil }
+
+// Persists the token cache to a private file and reads it back.
+func Test_token_cache_round_trip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timesheet", "token_cache.json")
+	tc := tokenCache{path}
+
+	empty := &bytesCache{}
+	assert.NoError(t, tc.Replace(context.Background(), empty, cache.ReplaceHints{}))
+	assert.Nil(t, empty.data)
+
+	assert.NoError(t, tc.Export(context.Background(), &bytesCache{[]byte(`{"tokens":1}`)}, cache.ExportHints{}))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	got := &bytesCache{}
+	assert.NoError(t, tc.Replace(context.Background(), got, cache.ReplaceHints{}))
+	assert.Equal(t, `{"tokens":1}`, string(got.data))
+}
+
+// Rejects incomplete or unknown authentication configuration.
+func Test_new_credential_validates_mode(t *testing.T) {
+	_, _, err := newCredential(domain.Auth{Mode: "kerberos"})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	_, _, err = newCredential(domain.Auth{Mode: domain.AuthDevice})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	_, scopes, err := newCredential(domain.Auth{Mode: domain.AuthDevice, ClientId: "c", TokenCache: filepath.Join(t.TempDir(), "cache.json")})
+	assert.NoError(t, err)
+	assert.Equal(t, delegatedScopes, scopes)
+
+	_, scopes, err = newCredential(domain.Auth{Mode: "Device", ClientId: "c", TokenCache: filepath.Join(t.TempDir(), "cache.json")})
+	assert.NoError(t, err)
+	assert.Equal(t, delegatedScopes, scopes)
+}
+
+// Chooses the mode from whichever credential is present and rejects ambiguous configuration.
+func Test_auth_mode_chosen_by_credentials(t *testing.T) {
+	mode, err := authMode(domain.Auth{ClientSecret: "s"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.AuthSecret, mode)
+
+	mode, err = authMode(domain.Auth{ClientCertificate: "cert.pem"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.AuthCertificate, mode)
+
+	mode, err = authMode(domain.Auth{FederatedTokenFile: "token"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.AuthWorkload, mode)
+
+	_, err = authMode(domain.Auth{})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	_, err = authMode(domain.Auth{ClientSecret: "s", ClientCertificate: "cert.pem"})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	assert.ErrorContains(t, err, "ambiguous")
+
+	mode, err = authMode(domain.Auth{Mode: domain.AuthCertificate, ClientSecret: "s", ClientCertificate: "cert.pem"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.AuthCertificate, mode)
+}
+
+// Constructs a certificate credential from a PEM file.
+func Test_new_credential_from_certificate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "TimeSheet"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+	assert.NoError(t, os.WriteFile(path, data, 0600))
+
+	_, scopes, err := newCredential(domain.Auth{TenantId: "t", ClientId: "c", ClientCertificate: path})
+	assert.NoError(t, err)
+	assert.Equal(t, applicationScopes, scopes)
+
+	_, _, err = newCredential(domain.Auth{TenantId: "t", ClientId: "c", ClientCertificate: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
@@ -0,0 +1,295 @@
+package svc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	jsonserialization "github.com/microsoft/kiota-serialization-json-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// An eventCache holds the events of one calendar over one date range together
+// with the delta link that fetches the changes made since they were synchronised.
+// Events are held as raw Graph JSON keyed by event id, with their times in
+// the reporting time zone with which they were fetched.
+type eventCache struct {
+	DeltaLink string                     `json:"deltaLink"`
+	Zone      string                     `json:"zone"`
+	Synced    time.Time                  `json:"synced"`
+	Events    map[string]json.RawMessage `json:"events"`
+}
+
+// Return the path of the cache file for a calendar of a user over a date range.
+// A delta link is only valid for the range with which it was created.
+// The range is keyed by its wall clock dates so that the cache is still found
+// when the reporting time zone cannot be read from the mailbox settings.
+func cachePath(dir string, userName string, ref CalendarRef, fromDate time.Time, toDate time.Time) string {
+	if userName == "" {
+		userName = "me"
+	}
+	key := strings.Join([]string{userName, ref.String(), fromDate.Format(wallClock), toDate.Format(wallClock)}, "|")
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// Load a cache file. A missing file is an empty cache.
+func loadEventCache(path string) (*eventCache, error) {
+	ec := &eventCache{Events: map[string]json.RawMessage{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ec, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: event cache: %w", domain.ErrConfig, err)
+	}
+	if err := json.Unmarshal(data, ec); err != nil {
+		return nil, fmt.Errorf("%w: event cache '%s': %w", domain.ErrParse, path, err)
+	}
+	if ec.Events == nil {
+		ec.Events = map[string]json.RawMessage{}
+	}
+	return ec, nil
+}
+
+// Write the cache file, readable only by the current user.
+func (ec *eventCache) save(path string) error {
+	data, err := json.Marshal(ec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("%w: event cache: %w", domain.ErrConfig, err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("%w: event cache: %w", domain.ErrConfig, err)
+	}
+	return nil
+}
+
+// Apply a set of changes from a delta query, adding or replacing changed
+// events and dropping those that have been removed from the calendar view.
+func (ec *eventCache) apply(changes []models.Eventable) error {
+	for _, ev := range changes {
+		id := eventKey(ev)
+		if ev.GetId() != nil {
+			id = *ev.GetId()
+		}
+		if _, removed := ev.GetAdditionalData()["@removed"]; removed {
+			delete(ec.Events, id)
+			continue
+		}
+		data, err := marshalEvent(ev)
+		if err != nil {
+			return err
+		}
+		ec.Events[id] = data
+	}
+	return nil
+}
+
+// The layout of the wall clock dates of a cache key.
+const wallClock = "2006-01-02T15:04:05"
+
+// Return the cached events.
+func (ec *eventCache) events() ([]models.Eventable, error) {
+	events := []models.Eventable{}
+	for _, data := range ec.Events {
+		ev, err := unmarshalEvent(data)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// Serialise an event as Graph JSON.
+func marshalEvent(ev models.Eventable) ([]byte, error) {
+	w := jsonserialization.NewJsonSerializationWriter()
+	defer w.Close()
+	if err := w.WriteObjectValue("", ev); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrParse, err)
+	}
+	return w.GetSerializedContent()
+}
+
+// Parse an event from Graph JSON.
+func unmarshalEvent(data []byte) (models.Eventable, error) {
+	node, err := jsonserialization.NewJsonParseNode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrParse, err)
+	}
+	v, err := node.GetObjectValue(models.CreateEventFromDiscriminatorValue)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrParse, err)
+	}
+	ev, ok := v.(models.Eventable)
+	if !ok {
+		return nil, fmt.Errorf("%w: not an event", domain.ErrParse)
+	}
+	return ev, nil
+}
+
+// Read the events of a calendar through the event cache.
+// The cache is first brought up to date from Microsoft Graph. If Graph cannot
+// be reached then the cached events are used, with a warning of their age,
+// and the time zone with which they were fetched is returned with them.
+func (svc graphSvc) cachedEvents(ctx context.Context, mailbox *users.UserItemRequestBuilder, userName string, ref CalendarRef, fromDate time.Time, toDate time.Time, zone string) ([]models.Eventable, string, error) {
+	path := cachePath(svc.cfg.CacheDir, userName, ref, fromDate, toDate)
+	ec, err := loadEventCache(path)
+	if err != nil {
+		return nil, "", err
+	}
+	err = svc.sync(ctx, mailbox, ref, fromDate, toDate, zone, ec)
+	if err != nil {
+		if !errors.Is(err, domain.ErrNetwork) || ec.Synced.IsZero() {
+			return nil, "", err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: unable to reach Microsoft Graph; using events from calendar '%s' cached %s ago.\n", ref, time.Since(ec.Synced).Round(time.Minute))
+		if svc.cfg.Verbose {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if ec.Zone != "" {
+			zone = ec.Zone
+		}
+		events, err := ec.events()
+		return events, zone, err
+	}
+	if err := ec.save(path); err != nil {
+		return nil, "", err
+	}
+	events, err := ec.events()
+	return events, zone, err
+}
+
+// Bring the cache up to date with a calendarView delta query.
+// The first synchronisation fetches every event in the date range and later
+// ones follow the saved delta link to fetch only what has changed since.
+// If Graph no longer recognises the delta link, or the events were fetched
+// in another time zone and so over a different range, then we start again.
+func (svc graphSvc) sync(ctx context.Context, mailbox *users.UserItemRequestBuilder, ref CalendarRef, fromDate time.Time, toDate time.Time, zone string, ec *eventCache) error {
+	calendarId, err := svc.calendarId(ctx, mailbox, ref)
+	if err != nil {
+		return err
+	}
+	deltaLink := ec.DeltaLink
+	if ec.Zone != zone {
+		deltaLink = ""
+	}
+	changes, nextLink, err := svc.calendarDelta(ctx, mailbox, calendarId, fromDate, toDate, zone, deltaLink)
+	if err != nil && deltaLink != "" && isSyncReset(err) {
+		deltaLink = ""
+		changes, nextLink, err = svc.calendarDelta(ctx, mailbox, calendarId, fromDate, toDate, zone, "")
+	}
+	if err != nil {
+		return graphError(err)
+	}
+	if deltaLink == "" {
+		*ec = eventCache{Events: map[string]json.RawMessage{}}
+	}
+	if err := ec.apply(changes); err != nil {
+		return err
+	}
+	ec.DeltaLink = nextLink
+	ec.Zone = zone
+	ec.Synced = time.Now()
+	return nil
+}
+
+// A page of a calendarView delta query.
+type deltaPage interface {
+	GetValue() []models.Eventable
+	GetOdataNextLink() *string
+	GetOdataDeltaLink() *string
+}
+
+// Read the changes to the events of a calendar, following every page, and
+// return them with the delta link for the next synchronisation.
+// An empty deltaLink starts a full synchronisation of the date range.
+// Delta queries do not support $select or $filter and so all-day events are
+// returned whether or not they are wanted.
+func (svc graphSvc) calendarDelta(ctx context.Context, mailbox *users.UserItemRequestBuilder, calendarId string, fromDate time.Time, toDate time.Time, zone string, deltaLink string) ([]models.Eventable, string, error) {
+	start := fromDate.UTC().Format(time.RFC3339)
+	end := toDate.UTC().Format(time.RFC3339)
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("Prefer", fmt.Sprintf(`outlook.timezone="%s"`, zone))
+	headers.Add("Prefer", fmt.Sprintf("odata.maxpagesize=%d", graphPageSize))
+	options := users.ItemCalendarCalendarViewDeltaRequestBuilderGetRequestConfiguration{
+		Headers: headers,
+	}
+
+	var page deltaPage
+	var err error
+	switch {
+	case deltaLink != "":
+		page, err = users.NewItemCalendarCalendarViewDeltaRequestBuilder(deltaLink, svc.client.GetAdapter()).GetAsDeltaGetResponse(ctx, &options)
+	case calendarId == "":
+		page, err = mailbox.Calendar().CalendarView().Delta().GetAsDeltaGetResponse(ctx, &users.ItemCalendarCalendarViewDeltaRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &users.ItemCalendarCalendarViewDeltaRequestBuilderGetQueryParameters{
+				StartDateTime: &start,
+				EndDateTime:   &end,
+			},
+		})
+	default:
+		page, err = mailbox.Calendars().ByCalendarId(calendarId).CalendarView().Delta().GetAsDeltaGetResponse(ctx, &users.ItemCalendarsItemCalendarViewDeltaRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &users.ItemCalendarsItemCalendarViewDeltaRequestBuilderGetQueryParameters{
+				StartDateTime: &start,
+				EndDateTime:   &end,
+			},
+		})
+	}
+	changes := []models.Eventable{}
+	for {
+		if err != nil {
+			return nil, "", err
+		}
+		if page == nil {
+			return nil, "", fmt.Errorf("empty delta response")
+		}
+		svc.stats.Pages++
+		svc.stats.Events += len(page.GetValue())
+		changes = append(changes, page.GetValue()...)
+
+		// Every page but the last has a nextLink; the last has the deltaLink.
+		if next := page.GetOdataNextLink(); next != nil && *next != "" {
+			page, err = users.NewItemCalendarCalendarViewDeltaRequestBuilder(*next, svc.client.GetAdapter()).GetAsDeltaGetResponse(ctx, &options)
+			continue
+		}
+		if delta := page.GetOdataDeltaLink(); delta != nil && *delta != "" {
+			return changes, *delta, nil
+		}
+		return nil, "", fmt.Errorf("delta response has neither a nextLink nor a deltaLink")
+	}
+}
+
+// Report whether err means that a delta link has expired and that a full
+// synchronisation is needed.
+func isSyncReset(err error) bool {
+	var odataErr *odataerrors.ODataError
+	if !errors.As(err, &odataErr) {
+		return false
+	}
+	if odataErr.ResponseStatusCode == http.StatusGone {
+		return true
+	}
+	if main := odataErr.GetErrorEscaped(); main != nil && main.GetCode() != nil {
+		code := strings.ToLower(*main.GetCode())
+		return code == "syncstatenotfound" || code == "syncstateinvalid" || code == "resyncrequired"
+	}
+	return false
+}
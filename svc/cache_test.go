@@ -0,0 +1,138 @@
+package svc
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// Return n consecutive 15 minute events, each with an id.
+func deltaEvents(n int) []map[string]any {
+	events := quarterHours(n)
+	for i, ev := range events {
+		ev["id"] = "e" + string(rune('a'+i))
+	}
+	return events
+}
+
+func readSortedDescs(t *testing.T, gs graphSvc) ([]string, error) {
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	descs := []string{}
+	for _, task := range tasks {
+		descs = append(descs, task.Desc)
+	}
+	sort.Strings(descs)
+	return descs, err
+}
+
+// Fetches every event on the first run and only the changes on later runs.
+func Test_read_cache_synchronises_changes(t *testing.T) {
+	fg := newFakeGraph(t, deltaEvents(12))
+	cfg := TsConfig{TimeZone: "UTC", CacheDir: t.TempDir()}
+
+	descs, err := readSortedDescs(t, newTestGraphSvc(t, fg.URL, cfg))
+	assert.NoError(t, err)
+	assert.Equal(t, 12, len(descs))
+	assert.Equal(t, 2, len(fg.queries))
+	assert.Equal(t, novFrom.UTC().Format(time.RFC3339), fg.queries[0].Get("startDateTime"))
+
+	added := graphEvent("ProjectX - Doc - added", novFrom.Add(5*hr), novFrom.Add(6*hr))
+	added["id"] = "new"
+	changed := graphEvent("ProjectX - Doc - changed", novFrom, novFrom.Add(hr))
+	changed["id"] = "eb"
+	fg.changes = []map[string]any{
+		added,
+		changed,
+		{"id": "ea", "@removed": map[string]any{"reason": "deleted"}},
+	}
+	for i := range fg.events {
+		fg.events[i] = graphEvent("ProjectX - Doc - not fetched", novFrom, novFrom.Add(hr))
+	}
+	descs, err = readSortedDescs(t, newTestGraphSvc(t, fg.URL, cfg))
+	assert.NoError(t, err)
+	assert.Equal(t, 12, len(descs))
+	assert.Contains(t, descs, "added")
+	assert.Contains(t, descs, "changed")
+	assert.NotContains(t, descs, "entry 0")
+	assert.NotContains(t, descs, "entry 1")
+	assert.Equal(t, 3, len(fg.queries))
+	assert.Equal(t, "1", fg.queries[2].Get("$deltatoken"))
+}
+
+// Starts again with a full synchronisation when the delta link has expired.
+func Test_read_cache_resynchronises_expired_delta_link(t *testing.T) {
+	fg := newFakeGraph(t, deltaEvents(3))
+	cfg := TsConfig{TimeZone: "UTC", CacheDir: t.TempDir()}
+	_, err := readSortedDescs(t, newTestGraphSvc(t, fg.URL, cfg))
+	assert.NoError(t, err)
+
+	fg.token = "2"
+	fg.events = fg.events[:2]
+	descs, err := readSortedDescs(t, newTestGraphSvc(t, fg.URL, cfg))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"entry 0", "entry 1"}, descs)
+	assert.Equal(t, "", fg.queries[len(fg.queries)-1].Get("$deltatoken"))
+}
+
+// Reports from the cache when Microsoft Graph cannot be reached.
+func Test_read_cache_offline(t *testing.T) {
+	fg := newFakeGraph(t, deltaEvents(3))
+	cfg := TsConfig{TimeZone: "UTC", CacheDir: t.TempDir()}
+	online, err := readSortedDescs(t, newTestGraphSvc(t, fg.URL, cfg))
+	assert.NoError(t, err)
+	fg.Close()
+
+	offline, err := readSortedDescs(t, newTestGraphSvc(t, fg.URL, cfg))
+	assert.NoError(t, err)
+	assert.Equal(t, online, offline)
+
+	// Without a cache there is nothing to report.
+	cfg.CacheDir = t.TempDir()
+	_, err = readSortedDescs(t, newTestGraphSvc(t, fg.URL, cfg))
+	assert.ErrorIs(t, err, domain.ErrNetwork)
+}
+
+// Reads the cache offline when the mailbox time zone cannot be read, reporting
+// in the time zone with which the events were cached rather than the local one.
+func Test_read_cache_offline_in_mailbox_time_zone(t *testing.T) {
+	fg := newFakeGraph(t, deltaEvents(3))
+	fg.timeZone = "Tokyo Standard Time"
+	cfg := TsConfig{CacheDir: t.TempDir()}
+	online, err := newTestGraphSvc(t, fg.URL, cfg).Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(online))
+	fg.Close()
+
+	gs := newTestGraphSvc(t, fg.URL, cfg)
+	gs.local = time.UTC
+	offline, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, len(online), len(offline))
+	for _, task := range offline {
+		assert.Equal(t, online[0].Start.Location().String(), task.Start.Location().String())
+		assert.Equal(t, 9, task.Start.Hour())
+	}
+}
+
+// Events survive a round trip through the cache's JSON.
+func Test_event_json_round_trip(t *testing.T) {
+	fg := newFakeGraph(t, deltaEvents(1))
+	fg.events[0]["isAllDay"] = true
+	fg.events[0]["responseStatus"] = map[string]any{"response": "declined"}
+	cfg := TsConfig{TimeZone: "UTC", CacheDir: t.TempDir(), AllDay: 8 * hr}
+	gs := newTestGraphSvc(t, fg.URL, cfg)
+	changes, _, err := gs.calendarDelta(context.Background(), gs.client.Me(), "", novFrom, novTo, "UTC", "")
+	assert.NoError(t, err)
+	data, err := marshalEvent(changes[0])
+	assert.NoError(t, err)
+	ev, err := unmarshalEvent(data)
+	assert.NoError(t, err)
+	assert.Equal(t, eventKey(changes[0]), eventKey(ev))
+	assert.True(t, isAllDay(ev))
+	assert.True(t, excluded(ev, []string{ExcludeDeclined}))
+	assert.Equal(t, "ea", *ev.GetId())
+}
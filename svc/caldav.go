@@ -0,0 +1,169 @@
+package svc
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// CalDAVConfig identifies the CalDAV calendars to read, such as those of a
+// Nextcloud, Fastmail or Radicale server, and how to authenticate to the server.
+type CalDAVConfig struct {
+	URLs     []string // The URLs of the calendar collections.
+	Username string   // The user name for basic authentication.
+	Password string   // The password for basic authentication.
+	Token    string   // A bearer token, used in preference to basic authentication.
+}
+
+// caldavSvc implements domain.GraphSvc by querying CalDAV calendars.
+type caldavSvc struct {
+	cfg    TsConfig
+	client *http.Client
+}
+
+// NewCalDAVSvc returns a GraphSvc that reads events from the calendars of cfg.CalDAV.
+// Throttled requests are retried as they are for Microsoft Graph.
+func NewCalDAVSvc(cfg TsConfig) (domain.GraphSvc, error) {
+	if len(cfg.CalDAV.URLs) == 0 {
+		return nil, fmt.Errorf("%w: a CalDAV calendar URL is required", domain.ErrConfig)
+	}
+	for _, u := range cfg.CalDAV.URLs {
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("%w: bad CalDAV calendar URL '%s'", domain.ErrConfig, u)
+		}
+	}
+	if _, err := reportingLocation(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Exclude == nil {
+		cfg.Exclude = DefaultExclude
+	}
+	client := &http.Client{
+		Transport: retryTransport{next: http.DefaultTransport, cfg: cfg.Retry},
+		Timeout:   100 * time.Second,
+	}
+	return caldavSvc{cfg: cfg, client: client}, nil
+}
+
+// Read the events of each calendar that fall within the date range.
+// The server returns whole calendar objects and so recurring events are
+// expanded here, in the same way as for iCalendar files.
+func (svc caldavSvc) Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+	loc, err := reportingLocation(svc.cfg)
+	if err != nil {
+		return []domain.Task{}, err
+	}
+	fromDate = inZone(fromDate, loc)
+	toDate = inZone(toDate, loc)
+
+	seen := map[string]bool{}
+	tasks := []domain.Task{}
+	for _, u := range svc.cfg.CalDAV.URLs {
+		events, err := svc.query(ctx, u, fromDate, toDate, loc)
+		if err != nil {
+			return []domain.Task{}, err
+		}
+		tasks = append(tasks, icsTasks(svc.cfg, userName, SourceCalDAV, calendarName(u), events, fromDate, toDate, loc, seen)...)
+	}
+	return tasks, nil
+}
+
+// Return the name of a calendar collection: the last segment of its URL path.
+func calendarName(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return path.Base(strings.TrimSuffix(parsed.Path, "/"))
+}
+
+// A calendar-query REPORT for the events that overlap a time range.
+const calendarQuery = `<?xml version="1.0" encoding="utf-8" ?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+    <C:calendar-data/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="%s" end="%s"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>
+`
+
+// The parts of a WebDAV multistatus response that hold calendar data.
+type multistatus struct {
+	Responses []struct {
+		Href      string `xml:"href"`
+		Propstats []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				CalendarData string `xml:"calendar-data"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// Query a calendar collection for the events that overlap the date range.
+func (svc caldavSvc) query(ctx context.Context, calendarURL string, fromDate time.Time, toDate time.Time, loc *time.Location) ([]*icsEvent, error) {
+	const utc = "20060102T150405Z"
+	body := fmt.Sprintf(calendarQuery, fromDate.UTC().Format(utc), toDate.UTC().Format(utc))
+	req, err := http.NewRequestWithContext(ctx, "REPORT", calendarURL, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrConfig, err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+	switch {
+	case svc.cfg.CalDAV.Token != "":
+		req.Header.Set("Authorization", "Bearer "+svc.cfg.CalDAV.Token)
+	case svc.cfg.CalDAV.Username != "":
+		req.SetBasicAuth(svc.cfg.CalDAV.Username, svc.cfg.CalDAV.Password)
+	}
+	resp, err := svc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrNetwork, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: CalDAV server refused access to '%s': %s", domain.ErrAuth, calendarURL, resp.Status)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: calendar not found: '%s'", domain.ErrConfig, calendarURL)
+	case resp.StatusCode != http.StatusMultiStatus:
+		return nil, fmt.Errorf("%w: CalDAV server returned %s for '%s'", domain.ErrNetwork, resp.Status, calendarURL)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrNetwork, err)
+	}
+	var ms multistatus
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, fmt.Errorf("%w: CalDAV response from '%s': %w", domain.ErrParse, calendarURL, err)
+	}
+	events := []*icsEvent{}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") || ps.Prop.CalendarData == "" {
+				continue
+			}
+			evs, err := readIcs(strings.NewReader(ps.Prop.CalendarData), loc)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", domain.ErrParse, r.Href, err)
+			}
+			events = append(events, evs...)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,176 @@
+package svc
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// A CalDAV server that answers calendar-query REPORTs for a single calendar,
+// returning every calendar object whatever the time range.
+type fakeCalDAV struct {
+	*httptest.Server
+	objects []string    // The iCalendar text of each calendar object.
+	ranges  [][2]string // The time range of each query.
+	auth    func(*http.Request) bool
+}
+
+func newFakeCalDAV(t *testing.T, objects ...string) *fakeCalDAV {
+	fc := &fakeCalDAV{objects: objects, auth: func(*http.Request) bool { return true }}
+	fc.Server = httptest.NewServer(http.HandlerFunc(fc.serve))
+	t.Cleanup(fc.Close)
+	return fc
+}
+
+func (fc *fakeCalDAV) serve(w http.ResponseWriter, r *http.Request) {
+	if !fc.auth(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "REPORT" || r.URL.Path != "/dav/calendars/john/work/" || r.Header.Get("Depth") != "1" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var query struct {
+		TimeRange struct {
+			Start string `xml:"start,attr"`
+			End   string `xml:"end,attr"`
+		} `xml:"filter>comp-filter>comp-filter>time-range"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	if err := xml.Unmarshal(body, &query); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fc.ranges = append(fc.ranges, [2]string{query.TimeRange.Start, query.TimeRange.End})
+
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav">`)
+	for i, obj := range fc.objects {
+		var data strings.Builder
+		xml.EscapeText(&data, []byte(obj))
+		fmt.Fprintf(&sb, `<d:response><d:href>%s%d.ics</d:href><d:propstat><d:prop><d:getetag>"%d"</d:getetag><cal:calendar-data>%s</cal:calendar-data></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, r.URL.Path, i, i, data.String())
+	}
+	sb.WriteString(`</d:multistatus>`)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, sb.String())
+}
+
+func (fc *fakeCalDAV) calendarURL() string {
+	return fc.URL + "/dav/calendars/john/work/"
+}
+
+func calendarObject(events string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.ReplaceAll(strings.TrimSpace(events), "\n", "\r\n") + "\r\nEND:VCALENDAR\r\n"
+}
+
+var caldavObjects = []string{
+	calendarObject(`
+BEGIN:VEVENT
+UID:review
+SUMMARY:ProjectX - Doc - Review
+DTSTART;TZID=Europe/Berlin:20231101T100000
+DTEND;TZID=Europe/Berlin:20231101T113000
+END:VEVENT`),
+	calendarObject(`
+BEGIN:VEVENT
+UID:standup
+SUMMARY:ACME - Support - standup
+DTSTART:20231106T090000Z
+DTEND:20231106T091500Z
+RRULE:FREQ=WEEKLY;COUNT=3
+END:VEVENT`),
+}
+
+// Reads and expands the events of a calendar through NewServices,
+// authenticating with a user name and password.
+func Test_caldav_reads_events(t *testing.T) {
+	fc := newFakeCalDAV(t, caldavObjects...)
+	fc.auth = func(r *http.Request) bool {
+		user, password, ok := r.BasicAuth()
+		return ok && user == "john" && password == "secret"
+	}
+	cfg := TsConfig{TimeZone: "UTC", CalDAV: CalDAVConfig{URLs: []string{fc.calendarURL()}, Username: "john", Password: "secret"}}
+	services, err := NewServices(cfg)
+	assert.NoError(t, err)
+	tasks, err := services.Graph.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][2]string{{"20231101T000000Z", "20231130T235959Z"}}, fc.ranges)
+	assert.Equal(t, 4, len(tasks))
+	assert.Equal(t, "Review", tasks[0].Desc)
+	assert.Equal(t, 9, tasks[0].Start.Hour())
+	assert.Equal(t, 90*min, tasks[0].Duration)
+	assert.Equal(t, "work", tasks[0].Calendar)
+	assert.Equal(t, SourceCalDAV, tasks[0].Source)
+	for _, task := range tasks[1:] {
+		assert.Equal(t, "standup", task.Desc)
+	}
+}
+
+func Test_caldav_bearer_token(t *testing.T) {
+	fc := newFakeCalDAV(t, caldavObjects...)
+	fc.auth = func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer abc" }
+	gs, err := NewCalDAVSvc(TsConfig{TimeZone: "UTC", CalDAV: CalDAVConfig{URLs: []string{fc.calendarURL()}, Token: "abc"}})
+	assert.NoError(t, err)
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(tasks))
+
+	gs, _ = NewCalDAVSvc(TsConfig{CalDAV: CalDAVConfig{URLs: []string{fc.calendarURL()}, Token: "wrong"}})
+	_, err = gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrAuth)
+}
+
+func Test_caldav_errors(t *testing.T) {
+	_, err := NewCalDAVSvc(TsConfig{})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	_, err = NewCalDAVSvc(TsConfig{CalDAV: CalDAVConfig{URLs: []string{"not a url"}}})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	fc := newFakeCalDAV(t, calendarObject("BEGIN:VEVENT\nSUMMARY:No start\nEND:VEVENT"))
+	gs, _ := NewCalDAVSvc(TsConfig{CalDAV: CalDAVConfig{URLs: []string{fc.URL + "/dav/calendars/john/other/"}}})
+	_, err = gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	gs, _ = NewCalDAVSvc(TsConfig{CalDAV: CalDAVConfig{URLs: []string{fc.calendarURL()}}})
+	_, err = gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrParse)
+	assert.ErrorContains(t, err, "/dav/calendars/john/work/0.ics")
+}
+
+// Chooses the source of events from the configuration.
+func Test_source_name(t *testing.T) {
+	assert.Equal(t, []string{SourceGraph}, SourceNames(TsConfig{}))
+	assert.Equal(t, []string{SourceReplay}, SourceNames(TsConfig{Replay: "november.json"}))
+	assert.Equal(t, []string{SourceIcs}, SourceNames(TsConfig{IcsFiles: []string{"work.ics"}}))
+	assert.Equal(t, []string{SourceCalDAV}, SourceNames(TsConfig{CalDAV: CalDAVConfig{URLs: []string{"https://dav.example.com/cal/"}}}))
+	assert.Equal(t, []string{SourceGraph}, SourceNames(TsConfig{Sources: []string{"Graph"}, IcsFiles: []string{"work.ics"}}))
+	assert.Equal(t, []string{SourceGraph, SourceIcs, SourceImport}, SourceNames(TsConfig{Sources: []string{"graph", "ics", "graph"}, ImportFiles: []string{"log.csv"}}))
+
+	// Every task records its source, even when it is the only one.
+	services, err := NewServices(TsConfig{Replay: "testdata/november.json", TimeZone: "UTC"})
+	assert.NoError(t, err)
+	tasks, err := services.Graph.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tasks)
+	for _, task := range tasks {
+		assert.Equal(t, SourceReplay, task.Source)
+	}
+
+	_, err = NewServices(TsConfig{Sources: []string{"exchange"}})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	_, err = NewServices(TsConfig{IcsFiles: []string{"work.ics"}, Record: "fixture.json"})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	_, err = NewServices(TsConfig{Replay: "testdata/november.json", ImportFiles: []string{"log.csv"}, Duplicates: "newest"})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
@@ -14,9 +14,13 @@ func NewCalendarSvc() domain.CalendarSvc {
 	return calendarSvc{}
 }
 
+// Aggregate the tasks by project. Unclassified tasks have no project and are left out.
 func (svc calendarSvc) Aggregate(tasks []domain.Task) []*domain.Project {
 	p := make(map[string]*domain.Project) // Map by task.Project string.
 	for _, task := range tasks {
+		if task.Unclassified {
+			continue
+		}
 		if _, ok := p[task.Project]; !ok {
 			p[task.Project] = domain.NewProject(task.Project)
 		}
@@ -62,8 +62,8 @@ func Test_summarizes_each_project(t *testing.T) {
 	assert.NotNil(t, projects[0].Groups)
 	assert.NotNil(t, projects[1].Groups)
 	assert.Equal(t, 1*hr+15*min, projects[0].Groups["Group 1"].Duration)
-	assert.Equal(t, 1*hr, projects[0].Summary["Group 1-Desc 1"].Duration)
-	assert.Equal(t, 15*min, projects[0].Summary["Group 1-Desc 2"].Duration)
+	assert.Equal(t, 1*hr, projects[0].Summary[domain.TaskKey{Group: "Group 1", Desc: "Desc 1"}].Duration)
+	assert.Equal(t, 15*min, projects[0].Summary[domain.TaskKey{Group: "Group 1", Desc: "Desc 2"}].Duration)
 }
 
 // Handles empty input task list.
@@ -87,6 +87,55 @@ func Test_handles_tasks_with_empty_project_name(t *testing.T) {
 	assert.Equal(t, "", projects[0].Name)
 }
 
+// Leaves out unclassified tasks, which are reported separately.
+func Test_leaves_out_unclassified_tasks(t *testing.T) {
+	tasks := []domain.Task{
+		{Project: "Project 1", Start: time.Now(), Duration: hr},
+		{Desc: "Early Lunch", Start: time.Now(), Duration: hr, Unclassified: true},
+	}
+	projects := svc.Aggregate(tasks)
+	assert.Equal(t, 1, len(projects))
+	assert.Equal(t, 1, len(projects[0].Tasks))
+}
+
+// Keeps apart tasks whose group and description would run together when hyphenated.
+func Test_summarises_hyphenated_groups_and_descriptions(t *testing.T) {
+	start := time.Now()
+	tasks := []domain.Task{
+		{Project: "Project 1", Group: "A", Desc: "B-C", Start: start, Duration: hr},
+		{Project: "Project 1", Group: "A-B", Desc: "C", Start: start, Duration: 30 * min},
+		{Project: "Project 1", Group: "A-B", Desc: "C", Start: start, Duration: 15 * min},
+		{Project: "Project 1", Group: "", Desc: "A-B-C", Start: start, Duration: 5 * min},
+	}
+	projects := svc.Aggregate(tasks)
+	assert.Equal(t, 1, len(projects))
+	summary := projects[0].Summary
+	assert.Equal(t, 3, len(summary))
+	assert.Equal(t, hr, summary[domain.TaskKey{Group: "A", Desc: "B-C"}].Duration)
+	assert.Equal(t, 45*min, summary[domain.TaskKey{Group: "A-B", Desc: "C"}].Duration)
+	assert.Equal(t, 5*min, summary[domain.TaskKey{Group: "", Desc: "A-B-C"}].Duration)
+	assert.Equal(t, 3, len(projects[0].Groups))
+	assert.Equal(t, hr, projects[0].Groups["A"].Duration)
+	assert.Equal(t, 45*min, projects[0].Groups["A-B"].Duration)
+}
+
+// Keeps apart tasks that differ only in their subgroups.
+func Test_summarises_subgroups_separately(t *testing.T) {
+	start := time.Now()
+	tasks := []domain.Task{
+		{Project: "Client", Group: "ProjA", Subgroups: []string{"WS1"}, Desc: "Task", Start: start, Duration: hr},
+		{Project: "Client", Group: "ProjA", Subgroups: []string{"WS2"}, Desc: "Task", Start: start, Duration: 30 * min},
+	}
+	projects := svc.Aggregate(tasks)
+	assert.Equal(t, 1, len(projects))
+	summary := projects[0].Summary
+	assert.Equal(t, 2, len(summary))
+	assert.Equal(t, hr, summary[tasks[0].Key()].Duration)
+	assert.Equal(t, 30*min, summary[tasks[1].Key()].Duration)
+	assert.Equal(t, []string{"WS2"}, summary[tasks[1].Key()].Subgroups)
+	assert.Equal(t, 90*min, projects[0].Groups["ProjA"].Duration)
+}
+
 func Test_calendarSvc_Aggregate(t *testing.T) {
 	start := time.Now()
 	type args struct {
@@ -110,14 +159,14 @@ func Test_calendarSvc_Aggregate(t *testing.T) {
 						{Project: "Project 1", Group: "Group 1", Desc: "Desc 1", Start: start, Duration: hr},
 						{Project: "Project 1", Group: "Group 1", Desc: "Desc 2", Start: start, Duration: 30 * min},
 					},
-					Summary: map[string]*domain.TaskSummary{
-						"Group 1-Desc 1": {
+					Summary: map[domain.TaskKey]*domain.TaskSummary{
+						{Group: "Group 1", Desc: "Desc 1"}: {
 							Group:    "Group 1",
 							Desc:     "Desc 1",
 							Duration: hr,
 							Started:  start,
 						},
-						"Group 1-Desc 2": {
+						{Group: "Group 1", Desc: "Desc 2"}: {
 							Group:    "Group 1",
 							Desc:     "Desc 2",
 							Duration: 30 * min,
@@ -131,6 +180,23 @@ func Test_calendarSvc_Aggregate(t *testing.T) {
 							Started:  start,
 						},
 					},
+					Tree: &domain.SummaryNode{
+						Name:     "Project 1",
+						Duration: hr + 30*min,
+						Started:  start,
+						Children: []*domain.SummaryNode{
+							{
+								Name:     "Group 1",
+								Duration: hr + 30*min,
+								Started:  start,
+								Children: []*domain.SummaryNode{
+									{Name: "Desc 1", Duration: hr, Started: start},
+									{Name: "Desc 2", Duration: 30 * min, Started: start},
+								},
+							},
+						},
+					},
+					Tags: map[domain.TagKey]*domain.TagSummary{},
 				},
 			},
 		},
@@ -0,0 +1,83 @@
+package svc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// The rules that decide how an event is classified when both its subject
+// and one of its categories give it a project.
+const (
+	PrecedenceSubject  = "subject"  // The subject's project and group are used.
+	PrecedenceCategory = "category" // The category's project and group are used.
+)
+
+// A CategoryRule gives the project and, optionally, the group of the events
+// in an Outlook category.
+type CategoryRule struct {
+	Project string
+	Group   string
+}
+
+// A CategoryMap maps Outlook category names, in lower case, to the project
+// and group of the events in each category.
+type CategoryMap map[string]CategoryRule
+
+// Parse a comma separated category mapping such as "Blue category=ACME:Dev,Admin=Internal",
+// in which each category is followed by the project and optional group of its events.
+func ParseCategoryMap(s string) (CategoryMap, error) {
+	categories := CategoryMap{}
+	for _, m := range strings.Split(s, ",") {
+		if strings.TrimSpace(m) == "" {
+			continue
+		}
+		category, target, _ := strings.Cut(m, "=")
+		project, group, _ := strings.Cut(target, ":")
+		category, project, group = strings.TrimSpace(category), strings.TrimSpace(project), strings.TrimSpace(group)
+		if category == "" || project == "" {
+			return nil, fmt.Errorf("%w: bad category mapping '%s' (expected 'category=project[:group]')", domain.ErrConfig, strings.TrimSpace(m))
+		}
+		categories[strings.ToLower(category)] = CategoryRule{Project: project, Group: group}
+	}
+	return categories, nil
+}
+
+// Check that a category precedence rule is known. The empty rule is PrecedenceSubject.
+func ParsePrecedence(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return PrecedenceSubject, nil
+	case PrecedenceSubject, PrecedenceCategory:
+		return s, nil
+	}
+	return "", fmt.Errorf("%w: unknown category precedence '%s' (expected '%s' or '%s')", domain.ErrConfig, s, PrecedenceSubject, PrecedenceCategory)
+}
+
+// Classify an event by its subject and categories. An event in a mapped
+// category takes its project and group from the first such category, and its
+// description from the subject, unless the subject also gives a project and
+// takes precedence. The description is then the whole subject or, if the
+// subject gives a project, the description that it gives.
+// An event that is classified by neither is returned as an Unclassified task.
+func classify(cfg TsConfig, subject string, categories []string) domain.Task {
+	task, ok := cfg.Subject.Parse(subject)
+	if ok && cfg.CategoryPrecedence != PrecedenceCategory {
+		return task
+	}
+	for _, category := range categories {
+		rule, mapped := cfg.Categories[strings.ToLower(strings.TrimSpace(category))]
+		if !mapped {
+			continue
+		}
+		if !ok {
+			task.Desc, task.Tags = splitTags(subject)
+		}
+		return domain.Task{Project: rule.Project, Group: rule.Group, Desc: task.Desc, Tags: task.Tags}
+	}
+	if !ok {
+		return domain.Task{Desc: subject, Unclassified: true}
+	}
+	return task
+}
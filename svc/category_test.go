@@ -0,0 +1,67 @@
+package svc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+func Test_parse_category_map(t *testing.T) {
+	categories, err := ParseCategoryMap(" Blue category = ACME:Dev, Admin=Internal,")
+	assert.NoError(t, err)
+	assert.Equal(t, CategoryMap{"blue category": {Project: "ACME", Group: "Dev"}, "admin": {Project: "Internal"}}, categories)
+
+	for _, s := range []string{"Blue", "=ACME", "Blue=:Dev"} {
+		_, err = ParseCategoryMap(s)
+		assert.ErrorIs(t, err, domain.ErrConfig, s)
+	}
+
+	precedence, err := ParsePrecedence("")
+	assert.NoError(t, err)
+	assert.Equal(t, PrecedenceSubject, precedence)
+	_, err = ParsePrecedence("calendar")
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
+
+// Classifies events by category, with the subject taking precedence unless configured otherwise.
+func Test_classify_by_category(t *testing.T) {
+	cfg := TsConfig{Categories: CategoryMap{"blue category": {Project: "ACME", Group: "Dev"}}}
+	categories := []string{"Red category", "Blue Category"}
+
+	task := classify(cfg, "Fix login #billable", categories)
+	assert.Equal(t, domain.Task{Project: "ACME", Group: "Dev", Desc: "Fix login", Tags: map[string]string{"billable": ""}}, task)
+	task = classify(cfg, "Fix login", []string{"Red category"})
+	assert.Equal(t, domain.Task{Desc: "Fix login", Unclassified: true}, task)
+
+	task = classify(cfg, "CompanyY - Support - Incident 42", categories)
+	assert.Equal(t, parsed("CompanyY", "Support", "Incident 42"), task)
+	cfg.CategoryPrecedence = PrecedenceCategory
+	task = classify(cfg, "CompanyY - Support - Incident 42", categories)
+	assert.Equal(t, parsed("ACME", "Dev", "Incident 42"), task)
+}
+
+// Reads the categories of Graph and iCalendar events.
+func Test_read_categories(t *testing.T) {
+	cfg := TsConfig{TimeZone: "UTC", Categories: CategoryMap{"blue category": {Project: "ACME", Group: "Dev"}}}
+	ev := graphEvent("Fix login", novFrom.Add(9*hr), novFrom.Add(10*hr))
+	ev["categories"] = []string{"Blue category"}
+	fg := newFakeGraph(t, []map[string]any{ev})
+	tasks, err := newTestGraphSvc(t, fg.URL, cfg).Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, []string{"ACME", "Dev", "Fix login"}, []string{tasks[0].Project, tasks[0].Group, tasks[0].Desc})
+
+	path := writeIcs(t, "work.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:Fix login
+CATEGORIES:Work\, urgent,Blue category
+DTSTART:20231101T100000Z
+DTEND:20231101T113000Z
+END:VEVENT`)
+	tasks = readIcsTasks(t, cfg, path)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "ACME", tasks[0].Project)
+}
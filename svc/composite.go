@@ -0,0 +1,162 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// The policies for resolving a task that is found in more than one source:
+// one with the same project, group and description whose time overlaps.
+const (
+	DuplicatesKeep    = "keep"    // Keep every copy.
+	DuplicatesFirst   = "first"   // Keep the copy from the source listed first.
+	DuplicatesLongest = "longest" // Keep the longest copy.
+	DuplicatesMerge   = "merge"   // Combine the copies into one covering all of their time.
+	DuplicatesError   = "error"   // Fail the report.
+)
+
+var duplicatePolicies = []string{DuplicatesKeep, DuplicatesFirst, DuplicatesLongest, DuplicatesMerge, DuplicatesError}
+
+// Check that a duplicate resolution policy is known. The empty policy is DuplicatesFirst.
+func ParseDuplicates(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return DuplicatesFirst, nil
+	}
+	if !slices.Contains(duplicatePolicies, s) {
+		return "", fmt.Errorf("%w: unknown duplicate policy '%s' (policies are %s)", domain.ErrConfig, s, strings.Join(duplicatePolicies, ", "))
+	}
+	return s, nil
+}
+
+// A source of tasks and its name, such as SourceGraph.
+type namedSource struct {
+	name  string
+	graph domain.GraphSvc
+}
+
+// compositeSvc implements domain.GraphSvc by reading from several sources at
+// once and merging their tasks. Each source records itself as the source of
+// its tasks, which the compositeSvc also ensures.
+type compositeSvc struct {
+	sources    []namedSource
+	policy     string
+	duplicates *int // The number of duplicates found by the most recent Read.
+}
+
+func newCompositeSvc(sources []namedSource, policy string) compositeSvc {
+	if policy == "" {
+		policy = DuplicatesFirst
+	}
+	return compositeSvc{sources: sources, policy: policy, duplicates: new(int)}
+}
+
+// Read every source concurrently. If any source fails then the others are
+// cancelled and the first failure is returned.
+func (svc compositeSvc) Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([][]domain.Task, len(svc.sources))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	for i, source := range svc.sources {
+		wg.Add(1)
+		go func(i int, source namedSource) {
+			defer wg.Done()
+			tasks, err := source.graph.Read(ctx, userName, fromDate, toDate)
+			if err != nil {
+				once.Do(func() {
+					first = fmt.Errorf("%s: %w", source.name, err)
+					cancel()
+				})
+				return
+			}
+			for j := range tasks {
+				tasks[j].Source = source.name
+			}
+			results[i] = tasks
+		}(i, source)
+	}
+	wg.Wait()
+	if first != nil {
+		return []domain.Task{}, first
+	}
+	return svc.merge(results)
+}
+
+// Merge the tasks of each source, in the order in which the sources are
+// listed, resolving any duplicates according to the policy.
+func (svc compositeSvc) merge(results [][]domain.Task) ([]domain.Task, error) {
+	*svc.duplicates = 0
+	tasks := []domain.Task{}
+	bySubject := map[string][]int{} // Indexes into tasks.
+	for _, result := range results {
+		for _, task := range result {
+			key := strings.Join(append([]string{task.Project}, task.Path()...), "\x00")
+			dup := -1
+			for _, i := range bySubject[key] {
+				if tasks[i].Source != task.Source && overlap(tasks[i], task) {
+					dup = i
+					break
+				}
+			}
+			if dup < 0 {
+				bySubject[key] = append(bySubject[key], len(tasks))
+				tasks = append(tasks, task)
+				continue
+			}
+			*svc.duplicates++
+			existing := tasks[dup]
+			switch svc.policy {
+			case DuplicatesKeep:
+				bySubject[key] = append(bySubject[key], len(tasks))
+				tasks = append(tasks, task)
+			case DuplicatesLongest:
+				if task.Duration > existing.Duration {
+					tasks[dup] = task
+				}
+			case DuplicatesMerge:
+				start, end := existing.Start, existing.Start.Add(existing.Duration)
+				if task.Start.Before(start) {
+					start = task.Start
+				}
+				if e := task.Start.Add(task.Duration); e.After(end) {
+					end = e
+				}
+				tasks[dup].Start = start
+				tasks[dup].Duration = end.Sub(start)
+			case DuplicatesError:
+				return []domain.Task{}, fmt.Errorf("%w: '%s - %s - %s' at %s is in both %s and %s", domain.ErrConfig,
+					task.Project, task.Group, task.Desc, task.Start.Format("2006-01-02 15:04"), existing.Source, task.Source)
+			}
+		}
+	}
+	return tasks, nil
+}
+
+// Report whether the times of two tasks overlap.
+func overlap(a domain.Task, b domain.Task) bool {
+	return a.Start.Before(b.Start.Add(b.Duration)) && b.Start.Before(a.Start.Add(a.Duration))
+}
+
+// Stats returns the combined statistics of those sources that keep them,
+// along with the number of duplicates found.
+func (svc compositeSvc) Stats() GraphStats {
+	stats := GraphStats{Duplicates: *svc.duplicates}
+	for _, source := range svc.sources {
+		if s, ok := source.graph.(interface{ Stats() GraphStats }); ok {
+			st := s.Stats()
+			stats.Pages += st.Pages
+			stats.Events += st.Events
+			stats.Excluded += st.Excluded
+		}
+	}
+	return stats
+}
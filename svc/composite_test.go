@@ -0,0 +1,130 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// A GraphSvc that returns fixed tasks, or an error, once every source has started.
+type fakeSource struct {
+	tasks   []domain.Task
+	err     error
+	started *sync.WaitGroup
+}
+
+func (fs fakeSource) Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+	if fs.started != nil {
+		fs.started.Done()
+		fs.started.Wait()
+	}
+	if fs.err != nil {
+		return []domain.Task{}, fs.err
+	}
+	<-time.After(time.Millisecond)
+	if err := ctx.Err(); err != nil {
+		return []domain.Task{}, err
+	}
+	return slices.Clone(fs.tasks), nil
+}
+
+func at(hour int, minute int) time.Time {
+	return time.Date(2023, 11, 3, hour, minute, 0, 0, time.UTC)
+}
+
+func readComposite(t *testing.T, policy string, sources ...namedSource) ([]domain.Task, compositeSvc, error) {
+	t.Helper()
+	composite := newCompositeSvc(sources, policy)
+	tasks, err := composite.Read(context.Background(), testUser, novFrom, novTo)
+	return tasks, composite, err
+}
+
+// Reads every source at once and records the source of each task.
+func Test_composite_reads_sources_concurrently(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(2)
+	meeting := domain.Task{Project: "ProjectX", Desc: "Meeting", Start: at(9, 0), Duration: hr}
+	call := domain.Task{Project: "ProjectY", Desc: "Call", Start: at(11, 0), Duration: hr}
+	tasks, composite, err := readComposite(t, DuplicatesFirst,
+		namedSource{SourceGraph, fakeSource{tasks: []domain.Task{meeting}, started: &started}},
+		namedSource{SourceIcs, fakeSource{tasks: []domain.Task{call}, started: &started}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, SourceGraph, tasks[0].Source)
+	assert.Equal(t, SourceIcs, tasks[1].Source)
+	assert.Equal(t, 0, composite.Stats().Duplicates)
+}
+
+// Fails with the first error, naming its source.
+func Test_composite_reports_source_errors(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(2)
+	_, _, err := readComposite(t, DuplicatesFirst,
+		namedSource{SourceGraph, fakeSource{started: &started}},
+		namedSource{SourceCalDAV, fakeSource{err: fmt.Errorf("%w: access refused", domain.ErrAuth), started: &started}})
+	assert.ErrorIs(t, err, domain.ErrAuth)
+	assert.ErrorContains(t, err, "caldav: ")
+}
+
+// Resolves tasks with the same subject and overlapping times in different sources.
+func Test_composite_duplicate_policies(t *testing.T) {
+	graph := []domain.Task{
+		{Project: "ProjectX", Group: "Dev", Desc: "Meeting", Start: at(9, 0), Duration: hr},
+		{Project: "ProjectX", Group: "Dev", Desc: "Meeting", Start: at(14, 0), Duration: hr},
+	}
+	imported := []domain.Task{
+		{Project: "ProjectX", Group: "Dev", Desc: "Meeting", Start: at(9, 30), Duration: hr}, // Overlaps the first.
+		{Project: "ProjectX", Group: "Dev", Desc: "Meeting", Start: at(15, 0), Duration: hr}, // Follows the second.
+	}
+	sources := []namedSource{{SourceGraph, fakeSource{tasks: graph}}, {SourceImport, fakeSource{tasks: imported}}}
+
+	tasks, composite, err := readComposite(t, DuplicatesFirst, sources...)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, SourceGraph, tasks[0].Source)
+	assert.Equal(t, hr, tasks[0].Duration)
+	assert.Equal(t, 1, composite.Stats().Duplicates)
+
+	tasks, _, err = readComposite(t, DuplicatesKeep, sources...)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(tasks))
+
+	tasks, _, err = readComposite(t, DuplicatesMerge, sources...)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, at(9, 0), tasks[0].Start)
+	assert.Equal(t, 90*min, tasks[0].Duration)
+
+	long := []domain.Task{{Project: "ProjectX", Group: "Dev", Desc: "Meeting", Start: at(8, 30), Duration: 2 * hr}}
+	tasks, _, err = readComposite(t, DuplicatesLongest, sources[0], namedSource{SourceIcs, fakeSource{tasks: long}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, SourceIcs, tasks[0].Source)
+	assert.Equal(t, 2*hr, tasks[0].Duration)
+
+	_, _, err = readComposite(t, DuplicatesError, sources...)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	assert.ErrorContains(t, err, "in both graph and import")
+
+	// Overlapping tasks within one source are not duplicates.
+	tasks, _, err = readComposite(t, DuplicatesError, namedSource{SourceGraph, fakeSource{tasks: append(graph, imported...)}})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(tasks))
+}
+
+func Test_parse_duplicates(t *testing.T) {
+	policy, err := ParseDuplicates("")
+	assert.NoError(t, err)
+	assert.Equal(t, DuplicatesFirst, policy)
+	policy, err = ParseDuplicates("Merge")
+	assert.NoError(t, err)
+	assert.Equal(t, DuplicatesMerge, policy)
+	_, err = ParseDuplicates("newest")
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
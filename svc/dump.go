@@ -18,7 +18,9 @@ func NewDumpSvc(cfg TsConfig) domain.DumpSvc {
 	return dumpSvc{cfg}
 }
 
-func (svc dumpSvc) Projects(projects []*domain.Project) []string {
+// Return the lines of the report on the projects, followed by a section
+// listing the unclassified tasks if there are any.
+func (svc dumpSvc) Projects(projects []*domain.Project, unclassified []domain.Task) []string {
 	output := []string{}
 
 	// Return the sum of the durations of the tasks in the task list.
@@ -51,6 +53,9 @@ func (svc dumpSvc) Projects(projects []*domain.Project) []string {
 
 	dmyFormat := "02 Jan 2006"
 	output = append(output, fmt.Sprintf("For the Dates %s - %s", svc.cfg.DateFrom.Format(dmyFormat), svc.cfg.DateTo.Format(dmyFormat)))
+	if len(unclassified) > 0 {
+		output = append(output, fmt.Sprintf("Unclassified events: %d (%s)", len(unclassified), fmtLongTime(Time(unclassified))))
+	}
 	output = append(output, "")
 
 	for _, proj := range projects {
@@ -74,26 +79,83 @@ func (svc dumpSvc) Projects(projects []*domain.Project) []string {
 			output = append(output, fmt.Sprintf("w/b %s - %s = %s", fmtDate(d), eqn, fmtTime(sum)))
 		}
 
-		// Output the groups in order of start time of the earliest task.
-		groups := make([]*domain.GroupSummary, 0, len(proj.Groups))
-		for g := range proj.Groups {
-			groups = append(groups, proj.Groups[g])
-		}
-		slices.SortFunc(groups, func(a, b *domain.GroupSummary) int { return a.Started.Compare(b.Started) })
-		output = append(output, "")
-		for _, g := range groups {
-			output = append(output, fmt.Sprintf("- %s (%s)", g.Group, fmtLongTime(g.Duration)))
+		if proj.Tree.Depth() > 2 {
+			// Output a deeper hierarchy as a tree with a subtotal at every level.
+			output = append(output, "")
+			output = append(output, treeLines(proj.Tree.Children, 0)...)
+		} else {
+			// Output the groups in order of start time of the earliest task.
+			groups := make([]*domain.GroupSummary, 0, len(proj.Groups))
+			for g := range proj.Groups {
+				groups = append(groups, proj.Groups[g])
+			}
+			slices.SortFunc(groups, func(a, b *domain.GroupSummary) int {
+				if c := a.Started.Compare(b.Started); c != 0 {
+					return c
+				}
+				return strings.Compare(a.Group, b.Group)
+			})
+			output = append(output, "")
+			for _, g := range groups {
+				output = append(output, fmt.Sprintf("- %s (%s)", g.Group, fmtLongTime(g.Duration)))
+			}
+
+			// Output the task summaries in order of the start time of the earliest task.
+			tasks := make([]*domain.TaskSummary, 0, len(proj.Summary))
+			for s := range proj.Summary {
+				tasks = append(tasks, proj.Summary[s])
+			}
+			slices.SortFunc(tasks, func(a, b *domain.TaskSummary) int {
+				if c := a.Started.Compare(b.Started); c != 0 {
+					return c
+				}
+				if c := strings.Compare(a.Group, b.Group); c != 0 {
+					return c
+				}
+				if c := slices.Compare(a.Subgroups, b.Subgroups); c != 0 {
+					return c
+				}
+				return strings.Compare(a.Desc, b.Desc)
+			})
+			output = append(output, "")
+			for _, s := range tasks {
+				output = append(output, fmt.Sprintf("- %s %s (%s)", s.Group, s.Desc, fmtLongTime(s.Duration)))
+			}
 		}
 
-		// Output the task summaries in order of the start time of the earliest task.
-		tasks := make([]*domain.TaskSummary, 0, len(proj.Summary))
-		for s := range proj.Summary {
-			tasks = append(tasks, proj.Summary[s])
+		// Output the subtotals of the configured tags, each tag in the order
+		// given and its values in order of the start time of the earliest task.
+		if len(svc.cfg.SubtotalTags) > 0 {
+			tags := make([]*domain.TagSummary, 0, len(proj.Tags))
+			for _, ts := range proj.Tags {
+				if slices.Contains(svc.cfg.SubtotalTags, ts.Name) {
+					tags = append(tags, ts)
+				}
+			}
+			slices.SortFunc(tags, func(a, b *domain.TagSummary) int {
+				if c := slices.Index(svc.cfg.SubtotalTags, a.Name) - slices.Index(svc.cfg.SubtotalTags, b.Name); c != 0 {
+					return c
+				}
+				return a.Started.Compare(b.Started)
+			})
+			if len(tags) > 0 {
+				output = append(output, "")
+			}
+			for _, ts := range tags {
+				output = append(output, fmt.Sprintf("- %s (%s)", domain.FormatTag(ts.Name, ts.Value), fmtLongTime(ts.Duration)))
+			}
 		}
-		slices.SortFunc(tasks, func(a, b *domain.TaskSummary) int { return a.Started.Compare(b.Started) })
 		output = append(output, "")
-		for _, s := range tasks {
-			output = append(output, fmt.Sprintf("- %s %s (%s)", s.Group, s.Desc, fmtLongTime(s.Duration)))
+	}
+
+	// Output the unclassified tasks in start order so that their subjects can be corrected.
+	if len(unclassified) > 0 {
+		output = append(output, fmt.Sprintf("Unclassified = %s", fmtLongTime(Time(unclassified))))
+		output = append(output, "")
+		unclassified = slices.Clone(unclassified)
+		slices.SortFunc(unclassified, func(a, b domain.Task) int { return a.Start.Compare(b.Start) })
+		for _, t := range unclassified {
+			output = append(output, fmt.Sprintf("- %s %s %q (%s)", fmtDate(t.Start), t.Start.Format("15:04"), t.Desc, fmtLongTime(t.Duration)))
 		}
 		output = append(output, "")
 	}
@@ -101,6 +163,17 @@ func (svc dumpSvc) Projects(projects []*domain.Project) []string {
 	return output
 }
 
+// Return a line for each node and each of its descendants, which are
+// indented beneath it.
+func treeLines(nodes []*domain.SummaryNode, depth int) []string {
+	lines := []string{}
+	for _, n := range nodes {
+		lines = append(lines, fmt.Sprintf("%s- %s (%s)", strings.Repeat("  ", depth), n.Name, fmtLongTime(n.Duration)))
+		lines = append(lines, treeLines(n.Children, depth+1)...)
+	}
+	return lines
+}
+
 func fmtDate(d time.Time) string {
 	day := d.Day()
 	mon := d.Month()
@@ -0,0 +1,78 @@
+package svc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// Rules that exclude events from a timesheet even though their subject matches.
+const (
+	ExcludeDeclined  = "declined"  // Meetings that the user has declined.
+	ExcludeCancelled = "cancelled" // Meetings that the organizer has cancelled.
+	ExcludeTentative = "tentative" // Meetings tentatively accepted or shown as tentative.
+	ExcludeFree      = "free"      // Events shown as free.
+	ExcludePrivate   = "private"   // Events marked as private or confidential.
+)
+
+var excludeRules = []string{ExcludeDeclined, ExcludeCancelled, ExcludeTentative, ExcludeFree, ExcludePrivate}
+
+// The exclusion rules used when none are given.
+var DefaultExclude = []string{ExcludeDeclined, ExcludeCancelled}
+
+// Parse a comma separated list of exclusion rules.
+// Declined meetings are never worked time and so are always excluded.
+func ParseExclusions(s string) ([]string, error) {
+	rules := []string{ExcludeDeclined}
+	for _, r := range strings.Split(s, ",") {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if r == "" || slices.Contains(rules, r) {
+			continue
+		}
+		if !slices.Contains(excludeRules, r) {
+			return nil, fmt.Errorf("%w: unknown exclusion '%s'; expected one of %s", domain.ErrConfig, r, strings.Join(excludeRules, ", "))
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Report whether the event is excluded by any of the rules.
+func excluded(ev models.Eventable, rules []string) bool {
+	var response models.ResponseType = models.NONE_RESPONSETYPE
+	if status := ev.GetResponseStatus(); status != nil && status.GetResponse() != nil {
+		response = *status.GetResponse()
+	}
+	var showAs models.FreeBusyStatus = models.UNKNOWN_FREEBUSYSTATUS
+	if ev.GetShowAs() != nil {
+		showAs = *ev.GetShowAs()
+	}
+	for _, rule := range rules {
+		switch rule {
+		case ExcludeDeclined:
+			if response == models.DECLINED_RESPONSETYPE {
+				return true
+			}
+		case ExcludeCancelled:
+			if ev.GetIsCancelled() != nil && *ev.GetIsCancelled() {
+				return true
+			}
+		case ExcludeTentative:
+			if response == models.TENTATIVELYACCEPTED_RESPONSETYPE || showAs == models.TENTATIVE_FREEBUSYSTATUS {
+				return true
+			}
+		case ExcludeFree:
+			if showAs == models.FREE_FREEBUSYSTATUS {
+				return true
+			}
+		case ExcludePrivate:
+			if s := ev.GetSensitivity(); s != nil && (*s == models.PRIVATE_SENSITIVITY || *s == models.CONFIDENTIAL_SENSITIVITY) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package svc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// Events of every kind covered by the exclusion rules.
+func exclusionEvents() []map[string]any {
+	at := time.Date(2023, 11, 1, 9, 0, 0, 0, time.UTC)
+	event := func(desc string, props map[string]any) map[string]any {
+		ev := graphEvent("ACME - Meeting - "+desc, at, at.Add(hr))
+		for k, v := range props {
+			ev[k] = v
+		}
+		at = at.Add(hr)
+		return ev
+	}
+	return []map[string]any{
+		event("accepted", map[string]any{"responseStatus": map[string]any{"response": "accepted"}, "showAs": "busy"}),
+		event("declined", map[string]any{"responseStatus": map[string]any{"response": "declined"}}),
+		event("cancelled", map[string]any{"isCancelled": true}),
+		event("tentative", map[string]any{"responseStatus": map[string]any{"response": "tentativelyAccepted"}}),
+		event("free", map[string]any{"showAs": "free"}),
+		event("private", map[string]any{"sensitivity": "private"}),
+	}
+}
+
+func readDescs(t *testing.T, cfg TsConfig) []string {
+	fg := newFakeGraph(t, exclusionEvents())
+	tasks, err := newTestGraphSvc(t, fg.URL, cfg).Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	descs := []string{}
+	for _, task := range tasks {
+		descs = append(descs, task.Desc)
+	}
+	return descs
+}
+
+// Declined and cancelled meetings are excluded by default.
+func Test_read_excludes_declined_and_cancelled_by_default(t *testing.T) {
+	assert.Equal(t, []string{"accepted", "tentative", "free", "private"}, readDescs(t, TsConfig{}))
+}
+
+// Each exclusion rule may be selected.
+func Test_read_applies_selected_exclusions(t *testing.T) {
+	rules, err := ParseExclusions("tentative, free,private")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"accepted", "cancelled"}, readDescs(t, TsConfig{Exclude: rules}))
+}
+
+// Declined meetings are always excluded and unknown rules are rejected.
+func Test_parse_exclusions(t *testing.T) {
+	rules, err := ParseExclusions("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ExcludeDeclined}, rules)
+
+	rules, err = ParseExclusions("Cancelled,declined")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ExcludeDeclined, ExcludeCancelled}, rules)
+
+	_, err = ParseExclusions("busy")
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
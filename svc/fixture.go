@@ -0,0 +1,148 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// A fixture file holds the raw Graph events of a recorded run so that
+// reports can be produced, and tests run, without a tenant.
+type fixture struct {
+	UserName  string            `json:"userName"`
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Location  string            `json:"location"` // The reporting time zone.
+	Calendars []fixtureCalendar `json:"calendars"`
+}
+
+type fixtureCalendar struct {
+	Calendar string            `json:"calendar"` // The CalendarRef from which the events were read.
+	Events   []json.RawMessage `json:"events"`
+}
+
+// recordSvc reads from Microsoft Graph, exactly as graphSvc does, and also
+// saves the raw events that it reads to a fixture file.
+type recordSvc struct {
+	graphSvc
+	path string
+}
+
+// NewRecordSvc returns a GraphSvc that records every Read to the fixture file at path.
+func NewRecordSvc(cfg TsConfig, path string) (domain.GraphSvc, error) {
+	graph, err := NewGraphSvc(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return recordSvc{graphSvc: graph.(graphSvc), path: path}, nil
+}
+
+func (svc recordSvc) Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+	run, err := svc.fetch(ctx, userName, fromDate, toDate)
+	if err != nil {
+		return []domain.Task{}, err
+	}
+	fx := fixture{UserName: userName, From: run.fromDate, To: run.toDate, Location: run.loc.String()}
+	for _, cal := range run.calendars {
+		fc := fixtureCalendar{Calendar: cal.ref.String(), Events: []json.RawMessage{}}
+		for _, ev := range cal.events {
+			data, err := marshalEvent(ev)
+			if err != nil {
+				return []domain.Task{}, err
+			}
+			fc.Events = append(fc.Events, data)
+		}
+		fx.Calendars = append(fx.Calendars, fc)
+	}
+	data, err := json.MarshalIndent(fx, "", "  ")
+	if err != nil {
+		return []domain.Task{}, err
+	}
+	if err := os.WriteFile(svc.path, data, 0600); err != nil {
+		return []domain.Task{}, fmt.Errorf("%w: recording: %w", domain.ErrConfig, err)
+	}
+	return svc.tasks(run)
+}
+
+// replaySvc serves Read from a fixture file, parsing the recorded events
+// in the same way as graphSvc.
+type replaySvc struct {
+	graphSvc
+	path string
+}
+
+// NewReplaySvc returns a GraphSvc that reads events from the fixture file at path.
+// No credentials are needed.
+func NewReplaySvc(cfg TsConfig, path string) (domain.GraphSvc, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%w: a fixture file is required", domain.ErrConfig)
+	}
+	if cfg.TimeZone != "" {
+		if _, err := reportingZone(cfg.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	return replaySvc{graphSvc: newGraphSvc(cfg, nil), path: path}, nil
+}
+
+// Read the recorded events that overlap the date range.
+// The events are reported in the configured time zone if there is one and
+// otherwise in the time zone of the recording.
+func (svc replaySvc) Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+	*svc.stats = GraphStats{}
+	data, err := os.ReadFile(svc.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []domain.Task{}, fmt.Errorf("%w: fixture file not found: '%s'", domain.ErrConfig, svc.path)
+		}
+		return []domain.Task{}, fmt.Errorf("%w: %w", domain.ErrConfig, err)
+	}
+	var fx fixture
+	if err := json.Unmarshal(data, &fx); err != nil {
+		return []domain.Task{}, fmt.Errorf("%w: fixture file '%s': %w", domain.ErrParse, svc.path, err)
+	}
+	zone := svc.cfg.TimeZone
+	if zone == "" {
+		zone = fx.Location
+	}
+	loc, err := loadTimeZone(zone)
+	if err != nil {
+		return []domain.Task{}, fmt.Errorf("%w: fixture file '%s': %w", domain.ErrParse, svc.path, err)
+	}
+	run := graphRun{source: SourceReplay, zone: zone, loc: loc, fromDate: inZone(fromDate, loc), toDate: inZone(toDate, loc)}
+	for _, fc := range fx.Calendars {
+		cal := calendarEvents{ref: ParseCalendarRef(fc.Calendar)}
+		for _, raw := range fc.Events {
+			ev, err := unmarshalEvent(raw)
+			if err != nil {
+				return []domain.Task{}, err
+			}
+			svc.stats.Events++
+			if overlaps(ev, run.fromDate, run.toDate) {
+				cal.events = append(cal.events, ev)
+			}
+		}
+		run.calendars = append(run.calendars, cal)
+	}
+	return svc.tasks(run)
+}
+
+// Report whether an event overlaps the date range, as the calendarView would.
+// Events whose times cannot be parsed are kept so that the error is reported.
+func overlaps(ev models.Eventable, fromDate time.Time, toDate time.Time) bool {
+	start, err := eventTime(ev.GetStart())
+	if err != nil {
+		return true
+	}
+	end, err := eventTime(ev.GetEnd())
+	if err != nil {
+		return true
+	}
+	return start.Before(toDate) && end.After(fromDate)
+}
@@ -0,0 +1,112 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// Run the timesheet for the configuration and return its report.
+func runReport(t *testing.T, cfg TsConfig) string {
+	services, err := NewServices(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	ts := tsSvc{TimesheetServices: services, cfg: cfg, out: &out}
+	if err := ts.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// Produces the expected report from a recorded fixture without a tenant.
+func Test_run_replays_fixture(t *testing.T) {
+	want, err := os.ReadFile("testdata/november.txt")
+	assert.NoError(t, err)
+	cfg := TsConfig{Replay: "testdata/november.json", DateFrom: novFrom, DateTo: novTo}
+	assert.Equal(t, string(want), runReport(t, cfg))
+}
+
+// Fails once the report is written if too much time is unclassified.
+func Test_run_limits_unclassified_time(t *testing.T) {
+	cfg := TsConfig{Replay: "testdata/november.json", DateFrom: novFrom, DateTo: novTo, MaxUnclassified: 30 * min}
+	services, err := NewServices(cfg)
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	err = tsSvc{TimesheetServices: services, cfg: cfg, out: &out}.Run(context.Background())
+	assert.ErrorIs(t, err, domain.ErrParse)
+	assert.ErrorContains(t, err, "1 hr spent in 1 unclassified events exceeds the limit of 30 min")
+	assert.Contains(t, out.String(), `- 01/11/2023 12:00 "Early Lunch" (1 hr)`)
+
+	cfg.MaxUnclassified = hr
+	assert.Contains(t, runReport(t, cfg), "Unclassified events: 1 (1 hr)\n")
+
+	// Filtering by tag still reports and limits the unclassified time.
+	cfg.Tags = []string{"billable"}
+	cfg.MaxUnclassified = 30 * min
+	out.Reset()
+	err = tsSvc{TimesheetServices: services, cfg: cfg, out: &out}.Run(context.Background())
+	assert.ErrorIs(t, err, domain.ErrParse)
+	assert.Contains(t, out.String(), `- 01/11/2023 12:00 "Early Lunch" (1 hr)`)
+}
+
+// Replays only the recorded events that fall within the date range.
+func Test_run_replay_date_range(t *testing.T) {
+	from := time.Date(2023, 11, 6, 0, 0, 0, 0, time.UTC)
+	cfg := TsConfig{Replay: "testdata/november.json", DateFrom: from, DateTo: novTo}
+	report := runReport(t, cfg)
+	assert.Contains(t, report, "ProjectX = 1 hr\n")
+	assert.NotContains(t, report, "CompanyY")
+}
+
+// Replays the same tasks as were read when recording.
+func Test_record_then_replay(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(30))
+	fg.events[3]["isCancelled"] = true
+	fg.calendars["u2/Calendar"] = quarterHours(35)[25:]
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	cfg := TsConfig{TimeZone: "Europe/London", Calendars: ParseCalendarRefs("default,team@oldgang.net")}
+	recorder := recordSvc{graphSvc: newTestGraphSvc(t, fg.URL, cfg), path: path}
+	recorded, err := recorder.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 34, len(recorded))
+
+	cfg.TimeZone = ""
+	replayer, err := NewReplaySvc(cfg, path)
+	assert.NoError(t, err)
+	replayed, err := replayer.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, len(recorded), len(replayed))
+	for i := range recorded {
+		assert.Equal(t, recorded[i].Desc, replayed[i].Desc)
+		assert.Equal(t, recorded[i].Calendar, replayed[i].Calendar)
+		assert.True(t, recorded[i].Start.Equal(replayed[i].Start))
+		assert.Equal(t, recorded[i].Start.Location().String(), replayed[i].Start.Location().String())
+		assert.Equal(t, recorded[i].Duration, replayed[i].Duration)
+	}
+	assert.Equal(t, 40, replayer.(replaySvc).Stats().Events)
+	assert.Equal(t, 1, replayer.(replaySvc).Stats().Excluded)
+}
+
+func Test_replay_errors(t *testing.T) {
+	_, err := NewServices(TsConfig{Record: "a.json", Replay: "b.json"})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	replayer, err := NewReplaySvc(TsConfig{}, filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(t, err)
+	_, err = replayer.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	assert.NoError(t, os.WriteFile(bad, []byte("{"), 0600))
+	replayer, _ = NewReplaySvc(TsConfig{}, bad)
+	_, err = replayer.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrParse)
+}
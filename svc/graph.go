@@ -2,125 +2,514 @@ package svc
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	azidentity "github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	kiotaabs "github.com/microsoft/kiota-abstractions-go/authentication"
+	kiotaauth "github.com/microsoft/kiota-authentication-azure-go"
+	khttp "github.com/microsoft/kiota-http-go"
 	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
 	"github.com/microsoftgraph/msgraph-sdk-go/users"
 	"github.com/vextasy/Timesheet_go/domain"
 )
 
+// GraphStats records how much data the most recent Read fetched from Microsoft Graph.
+type GraphStats struct {
+	Pages      int // Number of event pages fetched.
+	Events     int // Number of events received across all pages.
+	Excluded   int // Number of events excluded by the exclusion rules.
+	Duplicates int // Number of tasks found in more than one source.
+}
+
+// UserNotFoundError is returned by Read when the user name is not known to Microsoft Graph.
+type UserNotFoundError struct {
+	UserName string
+}
+
+func (e UserNotFoundError) Error() string {
+	return fmt.Sprintf("user not found: '%s'", e.UserName)
+}
+
+// An unknown user is a configuration error.
+func (e UserNotFoundError) Unwrap() error {
+	return domain.ErrConfig
+}
+
 // graphSvc implements domain.GraphSvc.
 type graphSvc struct {
-	auth   domain.Auth
+	cfg    TsConfig
 	client *msgraphsdk.GraphServiceClient
+	stats  *GraphStats
+	local  *time.Location // The time zone used when the mailbox time zone cannot be read.
 }
 
-func NewGraphSvc(auth domain.Auth) domain.GraphSvc {
-	cred, err := azidentity.NewClientSecretCredential(
-		auth.TenantId,
-		auth.ClientId,
-		auth.ClientSecret,
-		nil,
-	)
+// The format used by Microsoft Graph for a dateTimeTimeZone dateTime.
+const graphDateTime = "2006-01-02T15:04:05.0000000"
+
+// The maximum page size that we request from Microsoft Graph.
+const graphPageSize = 999
+
+func NewGraphSvc(cfg TsConfig) (domain.GraphSvc, error) {
+	if cfg.TimeZone != "" {
+		if _, err := reportingZone(cfg.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	cred, scopes, err := newCredential(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	authProvider, err := kiotaauth.NewAzureIdentityAuthenticationProviderWithScopes(cred, scopes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: creating authentication provider: %w", domain.ErrAuth, err)
+	}
+	client, err := newGraphClient(authProvider, cfg.Retry)
 	if err != nil {
-		fmt.Printf("Error creating credentials: %v\n", err)
-		return graphSvc{auth: auth, client: nil}
+		return nil, fmt.Errorf("%w: creating graph client: %w", domain.ErrAuth, err)
+	}
+	return newGraphSvc(cfg, client), nil
+}
+
+// Construct a Graph client whose requests are retried according to retry.
+// The SDK's own retry handler is replaced by a retryTransport so that there
+// is a single, configurable, retry policy. The client's timeout only applies
+// to requests whose context, as passed to Read, has no deadline of its own.
+func newGraphClient(authProvider kiotaabs.AuthenticationProvider, retry RetryConfig) (*msgraphsdk.GraphServiceClient, error) {
+	options := msgraphsdk.GetDefaultClientOptions()
+	middleware := []khttp.Middleware{}
+	for _, m := range msgraphcore.GetDefaultMiddlewaresWithOptions(&options) {
+		if _, ok := m.(*khttp.RetryHandler); !ok {
+			middleware = append(middleware, m)
+		}
 	}
-	scopes := []string{"https://graph.microsoft.com/.default"}
-	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, scopes)
+	httpClient := khttp.GetDefaultClient(middleware...)
+	httpClient.Transport = khttp.NewCustomTransportWithParentTransport(
+		retryTransport{next: khttp.GetDefaultTransport(), cfg: retry},
+		middleware...,
+	)
+	adapter, err := msgraphsdk.NewGraphRequestAdapterWithParseNodeFactoryAndSerializationWriterFactoryAndHttpClient(authProvider, nil, nil, httpClient)
 	if err != nil {
-		fmt.Printf("Error creating graph client: %v\n", err)
-		return graphSvc{auth: auth, client: nil}
+		return nil, err
+	}
+	return msgraphsdk.NewGraphServiceClient(adapter), nil
+}
+
+func newGraphSvc(cfg TsConfig, client *msgraphsdk.GraphServiceClient) graphSvc {
+	if cfg.Exclude == nil {
+		cfg.Exclude = DefaultExclude
 	}
 	return graphSvc{
-		auth:   auth,
+		cfg:    cfg,
 		client: client,
+		stats:  &GraphStats{},
+		local:  time.Local,
 	}
 }
 
-func (svc graphSvc) Read(userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+// Stats returns the page and event counts of the most recent Read.
+func (svc graphSvc) Stats() GraphStats {
+	return *svc.stats
+}
 
-	if svc.client == nil {
-		return []domain.Task{}, nil
-	}
-	allusers, err := svc.client.Users().Get(context.Background(), nil)
+func (svc graphSvc) Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+	run, err := svc.fetch(ctx, userName, fromDate, toDate)
 	if err != nil {
 		return []domain.Task{}, err
 	}
-	if allusers == nil || allusers.GetValue() == nil {
-		return []domain.Task{}, nil
+	return svc.tasks(run)
+}
+
+// The raw events read from each calendar, along with the reporting time zone
+// and the date range within that zone.
+type graphRun struct {
+	source    string // The name of the source of the events, such as SourceGraph.
+	zone      string
+	loc       *time.Location
+	fromDate  time.Time
+	toDate    time.Time
+	calendars []calendarEvents
+}
+
+type calendarEvents struct {
+	ref    CalendarRef
+	events []models.Eventable
+}
+
+// Fetch the events of every configured calendar from Microsoft Graph.
+func (svc graphSvc) fetch(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) (graphRun, error) {
+	*svc.stats = GraphStats{}
+	// With delegated authentication the user's own mailbox is that of the signed in user.
+	if svc.cfg.Auth.Delegated() {
+		userName = ""
 	}
-	var targetUser models.Userable
-	for _, user := range allusers.GetValue() {
-		if *user.GetUserPrincipalName() == userName {
-			targetUser = user
-		}
+	mailbox, err := svc.mailbox(ctx, userName)
+	if err != nil {
+		return graphRun{}, err
 	}
-	if targetUser == nil {
-		return []domain.Task{}, nil
+
+	// Events are reported in the reporting time zone and the date range
+	// is taken to be a range of wall clock times within that zone.
+	zone, loc, err := svc.timeZone(ctx, mailbox)
+	if err != nil {
+		return graphRun{}, err
 	}
+	run := graphRun{source: SourceGraph, zone: zone, loc: loc, fromDate: inZone(fromDate, loc), toDate: inZone(toDate, loc)}
 
-	// Got the user. Now get the tasks for that user.
-	// Microsoft graph stores datetimes in UTC. So convert our range to UTC before filtering.
-	start := fromDate.UTC().Format("2006-01-02T15:04:05.0000000")
-	end := toDate.UTC().Format("2006-01-02T15:04:05.0000000")
-	filter := fmt.Sprintf("start/DateTime ge '%s' and start/DateTime le '%s' and IsAllDay eq false", start, end)
-	query := users.ItemCalendarEventsRequestBuilderGetQueryParameters{
-		Select: []string{"subject", "start", "end"},
-		Filter: &filter,
-		Top:    &[]int32{999}[0],
+	// Got the user. Now get the events from each of the calendars.
+	refs := svc.cfg.Calendars
+	if len(refs) == 0 {
+		refs = []CalendarRef{{}}
 	}
-	options := users.ItemCalendarEventsRequestBuilderGetRequestConfiguration{
-		QueryParameters: &query,
+	for _, ref := range refs {
+		mb := mailbox
+		if ref.Mailbox != "" {
+			if mb, err = svc.mailbox(ctx, ref.Mailbox); err != nil {
+				return graphRun{}, err
+			}
+		}
+		events, eventZone, err := svc.events(ctx, mb, userName, ref, run.fromDate, run.toDate, run.zone)
+		if err != nil {
+			return graphRun{}, err
+		}
+		if eventZone != run.zone && svc.cfg.TimeZone == "" {
+			// The mailbox could not be reached and so report in the time zone of the cached events.
+			loc, err := loadTimeZone(eventZone)
+			if err != nil {
+				return graphRun{}, fmt.Errorf("%w: event cache: %w", domain.ErrParse, err)
+			}
+			run.zone, run.loc, run.fromDate, run.toDate = eventZone, loc, inZone(fromDate, loc), inZone(toDate, loc)
+		}
+		run.calendars = append(run.calendars, calendarEvents{ref: ref, events: events})
+	}
+	return run, nil
+}
+
+// Convert the events of a run into tasks.
+// The same event may appear in more than one calendar, for example a meeting
+// in both the user's and a shared mailbox, in which case only the first is kept.
+func (svc graphSvc) tasks(run graphRun) ([]domain.Task, error) {
+	seen := map[string]bool{}
+	tasks := []domain.Task{}
+	for _, cal := range run.calendars {
+		for _, ev := range cal.events {
+			key := eventKey(ev)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if isAllDay(ev) && svc.cfg.AllDay <= 0 {
+				continue
+			}
+			if excluded(ev, svc.cfg.Exclude) {
+				svc.stats.Excluded++
+				continue
+			}
+			task, ok, err := eventTask(ev, svc.cfg, run.loc)
+			if err != nil {
+				return []domain.Task{}, err
+			}
+			if !ok {
+				continue
+			}
+			task.Calendar = cal.ref.String()
+			task.Source = run.source
+			if isAllDay(ev) {
+				days, err := svc.allDayTasks(ev, task, run.fromDate, run.toDate, run.loc)
+				if err != nil {
+					return []domain.Task{}, err
+				}
+				tasks = append(tasks, days...)
+			} else {
+				tasks = append(tasks, task)
+			}
+		}
 	}
-	events, err := svc.client.Users().ByUserId(*targetUser.GetId()).Calendar().Events().Get(context.Background(), &options)
+	return tasks, nil
+}
+
+// Read the events of a calendar within the date range, through the event cache if there is one.
+// The time zone of the event times is returned with them.
+func (svc graphSvc) events(ctx context.Context, mailbox *users.UserItemRequestBuilder, userName string, ref CalendarRef, fromDate time.Time, toDate time.Time, zone string) ([]models.Eventable, string, error) {
+	if svc.cfg.CacheDir != "" {
+		return svc.cachedEvents(ctx, mailbox, userName, ref, fromDate, toDate, zone)
+	}
+	calendarId, err := svc.calendarId(ctx, mailbox, ref)
 	if err != nil {
-		return []domain.Task{}, err
+		return nil, "", err
 	}
-	if events == nil || events.GetValue() == nil {
-		return []domain.Task{}, nil
+	events, err := svc.calendarView(ctx, mailbox, calendarId, fromDate, toDate, zone)
+	return events, zone, err
+}
+
+// The event properties requested from Microsoft Graph.
+var eventFields = []string{"subject", "categories", "start", "end", "isAllDay", "iCalUId", "responseStatus", "isCancelled", "showAs", "sensitivity"}
+
+// Read the events of a calendar that fall within the date range, following every page.
+// The calendarView expands recurring series into their individual occurrences
+// and exceptions, each of which is returned as a separate event.
+// An empty calendarId is the mailbox's default calendar.
+func (svc graphSvc) calendarView(ctx context.Context, mailbox *users.UserItemRequestBuilder, calendarId string, fromDate time.Time, toDate time.Time, zone string) ([]models.Eventable, error) {
+	start := fromDate.UTC().Format(time.RFC3339)
+	end := toDate.UTC().Format(time.RFC3339)
+	// All-day events are only wanted if they are to be converted into working days.
+	var filter *string
+	if svc.cfg.AllDay <= 0 {
+		filter = &[]string{"isAllDay eq false"}[0]
 	}
-	tasks := []domain.Task{}
-	for _, ev := range events.GetValue() {
-		if ev == nil || ev.GetSubject() == nil {
-			continue
+	top := int32(graphPageSize)
+	// Ask for event times in the reporting time zone rather than UTC.
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("Prefer", fmt.Sprintf(`outlook.timezone="%s"`, zone))
+
+	var page models.EventCollectionResponseable
+	var err error
+	if calendarId == "" {
+		page, err = mailbox.Calendar().CalendarView().Get(ctx, &users.ItemCalendarCalendarViewRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &users.ItemCalendarCalendarViewRequestBuilderGetQueryParameters{
+				StartDateTime: &start,
+				EndDateTime:   &end,
+				Select:        eventFields,
+				Filter:        filter,
+				Top:           &top,
+			},
+		})
+	} else {
+		page, err = mailbox.Calendars().ByCalendarId(calendarId).CalendarView().Get(ctx, &users.ItemCalendarsItemCalendarViewRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+			QueryParameters: &users.ItemCalendarsItemCalendarViewRequestBuilderGetQueryParameters{
+				StartDateTime: &start,
+				EndDateTime:   &end,
+				Select:        eventFields,
+				Filter:        filter,
+				Top:           &top,
+			},
+		})
+	}
+	events := []models.Eventable{}
+	for {
+		if err != nil {
+			return nil, graphError(err)
+		}
+		if page == nil || page.GetValue() == nil {
+			break
 		}
+		svc.stats.Pages++
+		svc.stats.Events += len(page.GetValue())
+		events = append(events, page.GetValue()...)
 
-		// proj (- group) - description
-		var pat = `^\s*([\w/]+)(?:\s*-\s*([\w/]+))?\s*-\s*(.*)`
+		// Follow the @odata.nextLink until Graph reports that there are no more pages.
+		// The nextLink already carries the original query parameters.
+		next := page.GetOdataNextLink()
+		if next == nil || *next == "" {
+			break
+		}
+		options := users.ItemCalendarCalendarViewRequestBuilderGetRequestConfiguration{
+			Headers: headers,
+		}
+		page, err = users.NewItemCalendarCalendarViewRequestBuilder(*next, svc.client.GetAdapter()).Get(ctx, &options)
+	}
+	return events, nil
+}
 
-		matches := regexp.MustCompile(pat).FindStringSubmatch(*ev.GetSubject())
-		if matches == nil || len(matches) != 4 { // Entire expression plus each subexpression.
-			continue
+// Return the id of the calendar to which ref refers within the mailbox.
+// The default calendar is identified by an empty id.
+func (svc graphSvc) calendarId(ctx context.Context, mailbox *users.UserItemRequestBuilder, ref CalendarRef) (string, error) {
+	if ref.IsDefault() {
+		return "", nil
+	}
+	top := int32(graphPageSize)
+	options := users.ItemCalendarsRequestBuilderGetRequestConfiguration{
+		QueryParameters: &users.ItemCalendarsRequestBuilderGetQueryParameters{
+			Select: []string{"id", "name"},
+			Top:    &top,
+		},
+	}
+	calendars, err := mailbox.Calendars().Get(ctx, &options)
+	if err != nil {
+		return "", graphError(err)
+	}
+	if calendars != nil {
+		for _, cal := range calendars.GetValue() {
+			if cal.GetId() != nil && *cal.GetId() == ref.Calendar {
+				return *cal.GetId(), nil
+			}
+			if cal.GetId() != nil && cal.GetName() != nil && strings.EqualFold(*cal.GetName(), ref.Calendar) {
+				return *cal.GetId(), nil
+			}
 		}
-		proj := matches[1]
-		group := matches[2]
-		desc := matches[3]
-		_start := ev.GetStart()
-		_end := ev.GetEnd()
-		start, err := time.Parse("2006-01-02T15:04:05.0000000", *_start.GetDateTime())
-		if err != nil {
-			return []domain.Task{}, fmt.Errorf("failed to parse start time: %v", err)
+	}
+	return "", fmt.Errorf("%w: calendar not found: '%s'", domain.ErrConfig, ref)
+}
+
+// Return a key that identifies an event occurrence across calendars and mailboxes.
+// The iCalUId is shared by all copies of a meeting and differs for each occurrence
+// of a recurring series. Events without one are identified by subject and time.
+func eventKey(ev models.Eventable) string {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
 		}
-		end, err := time.Parse("2006-01-02T15:04:05.0000000", *_end.GetDateTime())
-		if err != nil {
-			return []domain.Task{}, fmt.Errorf("failed to parse end time: %v", err)
+		return *s
+	}
+	var start, end string
+	if ev.GetStart() != nil {
+		start = str(ev.GetStart().GetDateTime())
+	}
+	if ev.GetEnd() != nil {
+		end = str(ev.GetEnd().GetDateTime())
+	}
+	if uid := str(ev.GetICalUId()); uid != "" {
+		return uid + "|" + start
+	}
+	return str(ev.GetSubject()) + "|" + start + "|" + end
+}
+
+// Return the request builder for the mailbox of the named user.
+// With delegated authentication an empty name is the signed in user and
+// other mailboxes are addressed directly by user principal name.
+func (svc graphSvc) mailbox(ctx context.Context, userName string) (*users.UserItemRequestBuilder, error) {
+	if svc.cfg.Auth.Delegated() {
+		if userName == "" {
+			return svc.client.Me(), nil
 		}
-		// Convert back from UTC to local time.
-		start = start.Local()
-		end = end.Local()
-		duration := end.Sub(start)
-		tasks = append(tasks, domain.Task{
-			Project:  proj,
-			Group:    group,
-			Desc:     desc,
-			Start:    start,
-			Duration: duration,
-		})
+		return svc.client.Users().ByUserId(userName), nil
 	}
-	return tasks, nil
+	user, err := svc.user(ctx, userName)
+	if err != nil && svc.cfg.CacheDir != "" && errors.Is(err, domain.ErrNetwork) {
+		// Carry on so that any cached events can still be reported.
+		return svc.client.Users().ByUserId(userName), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return svc.client.Users().ByUserId(*user.GetId()), nil
+}
+
+// Look up a user directly by their user principal name.
+// A UserNotFoundError is returned if Microsoft Graph does not know the user.
+func (svc graphSvc) user(ctx context.Context, userName string) (models.Userable, error) {
+	query := users.UserItemRequestBuilderGetQueryParameters{
+		Select: []string{"id", "userPrincipalName"},
+	}
+	options := users.UserItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &query,
+	}
+	user, err := svc.client.Users().ByUserId(userName).Get(ctx, &options)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, UserNotFoundError{UserName: userName}
+		}
+		return nil, graphError(err)
+	}
+	if user == nil || user.GetId() == nil {
+		return nil, UserNotFoundError{UserName: userName}
+	}
+	return user, nil
+}
+
+// Determine the name and location of the reporting time zone.
+// This is the configured TimeZone if there is one and otherwise the time zone
+// of the user's mailbox settings. If neither is available then events are
+// requested in UTC and reported in the local time zone.
+func (svc graphSvc) timeZone(ctx context.Context, mailbox *users.UserItemRequestBuilder) (string, *time.Location, error) {
+	if svc.cfg.TimeZone != "" {
+		loc, err := reportingZone(svc.cfg.TimeZone)
+		return svc.cfg.TimeZone, loc, err
+	}
+	settings, err := mailbox.MailboxSettings().Get(ctx, nil)
+	if ctx.Err() != nil {
+		return "", nil, graphError(ctx.Err())
+	}
+	if err != nil || settings == nil || settings.GetTimeZone() == nil || *settings.GetTimeZone() == "" {
+		if svc.cfg.Verbose {
+			fmt.Fprintln(os.Stderr, "Unable to read the mailbox time zone; using the local time zone.")
+		}
+		return "UTC", svc.local, nil
+	}
+	zone := *settings.GetTimeZone()
+	loc, err := loadTimeZone(zone)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: mailbox settings: %w", domain.ErrParse, err)
+	}
+	return zone, loc, nil
+}
+
+// Categorise an error returned by a Microsoft Graph request.
+// A failure to acquire a token is an authentication error and anything else
+// is treated as a failure to communicate with the service.
+func graphError(err error) error {
+	if errors.Is(err, domain.ErrAuth) {
+		return err
+	}
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		return fmt.Errorf("%w: %w", domain.ErrAuth, err)
+	}
+	return fmt.Errorf("%w: %w", domain.ErrNetwork, err)
+}
+
+// Report whether err is a Microsoft Graph "resource not found" error.
+// This version of the SDK does not always populate the response status code
+// and so we also look at the error code within the response body.
+func isNotFound(err error) bool {
+	var odataErr *odataerrors.ODataError
+	if !errors.As(err, &odataErr) {
+		return false
+	}
+	if odataErr.ResponseStatusCode == http.StatusNotFound {
+		return true
+	}
+	if main := odataErr.GetErrorEscaped(); main != nil && main.GetCode() != nil {
+		return *main.GetCode() == "Request_ResourceNotFound" || *main.GetCode() == "ResourceNotFound"
+	}
+	return false
+}
+
+// Convert an Outlook event into a Task whose start time is in the location loc.
+// The task is classified by the event's subject and categories, and the
+// boolean result is false if the event has no subject at all.
+func eventTask(ev models.Eventable, cfg TsConfig, loc *time.Location) (domain.Task, bool, error) {
+	if ev == nil || ev.GetSubject() == nil {
+		return domain.Task{}, false, nil
+	}
+	task := classify(cfg, *ev.GetSubject(), ev.GetCategories())
+	start, err := eventTime(ev.GetStart())
+	if err != nil {
+		return domain.Task{}, false, fmt.Errorf("%w: failed to parse start time: %v", domain.ErrParse, err)
+	}
+	end, err := eventTime(ev.GetEnd())
+	if err != nil {
+		return domain.Task{}, false, fmt.Errorf("%w: failed to parse end time: %v", domain.ErrParse, err)
+	}
+	// Both times are absolute and so the duration is correct across DST transitions.
+	task.Start = start.In(loc)
+	task.Duration = end.Sub(start)
+	return task, true, nil
+}
+
+// Convert a Graph dateTimeTimeZone into a time.
+// The time zone may be an IANA or a Windows time zone name and defaults to UTC.
+func eventTime(dt models.DateTimeTimeZoneable) (time.Time, error) {
+	if dt == nil || dt.GetDateTime() == nil {
+		return time.Time{}, errors.New("missing dateTime")
+	}
+	zone := "UTC"
+	if dt.GetTimeZone() != nil && *dt.GetTimeZone() != "" {
+		zone = *dt.GetTimeZone()
+	}
+	loc, err := loadTimeZone(zone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(graphDateTime, *dt.GetDateTime(), loc)
 }
@@ -0,0 +1,361 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/kiota-abstractions-go/authentication"
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+const testUser = "john.bates@oldgang.net"
+const teamUser = "team@oldgang.net"
+
+// A fake Microsoft Graph service that knows about the test user, whose default
+// calendar view holds the given events, and a shared team mailbox.
+// Further calendars may be added to either mailbox.
+type fakeGraph struct {
+	*httptest.Server
+	events    []map[string]any            // The test user's default calendar.
+	calendars map[string][]map[string]any // Other calendars keyed by "mailbox id/calendar name".
+	timeZone  string                      // The mailbox time zone.
+	queries   []url.Values                // The query parameters of each calendarView request.
+	prefer    []string                    // The Prefer header of each calendarView request.
+	throttle  int                         // The number of calendarView requests to refuse with a 429 response.
+	changes   []map[string]any            // The changes returned by the next delta query of the default calendar.
+	token     string                      // The current delta token; other tokens have expired.
+}
+
+func newFakeGraph(t *testing.T, events []map[string]any) *fakeGraph {
+	fg := &fakeGraph{events: events, calendars: map[string][]map[string]any{}, timeZone: "UTC", token: "1"}
+	fg.Server = httptest.NewServer(http.HandlerFunc(fg.serve))
+	t.Cleanup(fg.Close)
+	return fg
+}
+
+// The ids of the users known to the fake.
+var fakeUsers = map[string]string{testUser: "u1", teamUser: "u2"}
+
+func (fg *fakeGraph) serve(w http.ResponseWriter, r *http.Request) {
+	// The signed in user's mailbox is the same as that of the test user.
+	path := strings.Replace(r.URL.Path, "/me/", "/users/u1/", 1)
+	parts := strings.Split(strings.TrimPrefix(path, "/users/"), "/")
+	switch {
+	case len(parts) == 1:
+		if id, ok := fakeUsers[parts[0]]; ok {
+			writeJSON(w, map[string]any{"id": id, "userPrincipalName": parts[0]})
+			return
+		}
+	case len(parts) == 2 && parts[1] == "mailboxSettings":
+		writeJSON(w, map[string]any{"timeZone": fg.timeZone})
+		return
+	case len(parts) == 2 && parts[1] == "calendars":
+		cals := []map[string]any{{"id": "default-" + parts[0], "name": "Calendar"}}
+		for key := range fg.calendars {
+			if mailbox, name, _ := strings.Cut(key, "/"); mailbox == parts[0] {
+				cals = append(cals, map[string]any{"id": "id-" + name, "name": name})
+			}
+		}
+		writeJSON(w, map[string]any{"value": cals})
+		return
+	case len(parts) == 3 && parts[1] == "calendar" && parts[2] == "calendarView":
+		if parts[0] == "u1" {
+			fg.calendarView(w, r, fg.events)
+		} else {
+			fg.calendarView(w, r, fg.calendars[parts[0]+"/Calendar"])
+		}
+		return
+	case len(parts) == 4 && parts[0] == "u1" && parts[1] == "calendar" && parts[2] == "calendarView" && parts[3] == "delta()":
+		fg.delta(w, r)
+		return
+	case len(parts) == 4 && parts[1] == "calendars" && parts[3] == "calendarView":
+		if events, ok := fg.calendars[parts[0]+"/"+strings.TrimPrefix(parts[2], "id-")]; ok {
+			fg.calendarView(w, r, events)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": "Request_ResourceNotFound", "message": "Resource does not exist."},
+	})
+}
+
+// Serve a page of events using $top and $skip, with a nextLink to any following page.
+func (fg *fakeGraph) calendarView(w http.ResponseWriter, r *http.Request, events []map[string]any) {
+	q := r.URL.Query()
+	fg.queries = append(fg.queries, q)
+	if fg.throttle > 0 {
+		fg.throttle--
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	fg.prefer = append(fg.prefer, r.Header.Get("Prefer"))
+	top, _ := strconv.Atoi(q.Get("$top"))
+	skip, _ := strconv.Atoi(q.Get("$skip"))
+	if top <= 0 {
+		top = 10
+	}
+	page := []map[string]any{}
+	for i := skip; i < len(events) && i < skip+top; i++ {
+		page = append(page, events[i])
+	}
+	body := map[string]any{"value": page}
+	if skip+top < len(events) {
+		q.Set("$skip", strconv.Itoa(skip+top))
+		body["@odata.nextLink"] = fg.URL + r.URL.Path + "?" + q.Encode()
+	}
+	writeJSON(w, body)
+}
+
+// Serve a delta query of the test user's default calendar.
+// A full synchronisation pages through the events and a delta token
+// returns the pending changes. Both finish with a new deltaLink.
+func (fg *fakeGraph) delta(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	fg.queries = append(fg.queries, q)
+	deltaLink := fg.URL + r.URL.Path + "?$deltatoken=" + fg.token
+	if token := q.Get("$deltatoken"); token != "" {
+		if token != fg.token {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGone)
+			json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{"code": "SyncStateNotFound", "message": "The sync state is no longer valid."},
+			})
+			return
+		}
+		writeJSON(w, map[string]any{"value": fg.changes, "@odata.deltaLink": deltaLink})
+		fg.changes = nil
+		return
+	}
+	skip, _ := strconv.Atoi(q.Get("$skip"))
+	page := []map[string]any{}
+	for i := skip; i < len(fg.events) && i < skip+10; i++ {
+		page = append(page, fg.events[i])
+	}
+	body := map[string]any{"value": page}
+	if skip+10 < len(fg.events) {
+		q.Set("$skip", strconv.Itoa(skip+10))
+		body["@odata.nextLink"] = fg.URL + r.URL.Path + "?" + q.Encode()
+	} else {
+		body["@odata.deltaLink"] = deltaLink
+	}
+	writeJSON(w, body)
+}
+
+// Return n consecutive 15 minute events starting at midnight UTC on 1st November 2023.
+func quarterHours(n int) []map[string]any {
+	base := time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)
+	events := []map[string]any{}
+	for i := 0; i < n; i++ {
+		start := base.Add(time.Duration(i) * 15 * min)
+		events = append(events, graphEvent(fmt.Sprintf("ProjectX - Doc - entry %d", i), start, start.Add(15*min)))
+	}
+	return events
+}
+
+func graphEvent(subject string, start time.Time, end time.Time) map[string]any {
+	return map[string]any{
+		"subject": subject,
+		"start":   map[string]any{"dateTime": start.UTC().Format(graphDateTime), "timeZone": "UTC"},
+		"end":     map[string]any{"dateTime": end.UTC().Format(graphDateTime), "timeZone": "UTC"},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func newTestGraphSvc(t *testing.T, url string, cfg TsConfig) graphSvc {
+	client, err := newGraphClient(&authentication.AnonymousAuthenticationProvider{}, cfg.Retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.GetAdapter().SetBaseUrl(url)
+	return newGraphSvc(cfg, client)
+}
+
+var novFrom = time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)
+var novTo = time.Date(2023, 11, 30, 23, 59, 59, 0, time.UTC)
+
+// Reads every page of events by following the nextLink.
+func Test_read_follows_next_link_across_pages(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(2500))
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{})
+
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 2500, len(tasks))
+	assert.Equal(t, GraphStats{Pages: 3, Events: 2500}, gs.Stats())
+	assert.Equal(t, "entry 0", tasks[0].Desc)
+	assert.Equal(t, "entry 2499", tasks[2499].Desc)
+	assert.Equal(t, SourceGraph, tasks[0].Source)
+}
+
+// A single page is read when there is no nextLink.
+func Test_read_single_page(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(3))
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{})
+
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, GraphStats{Pages: 1, Events: 3}, gs.Stats())
+	assert.Equal(t, 15*min, tasks[0].Duration)
+}
+
+// Queries the calendar view over the date range so that each
+// occurrence of a recurring event is returned as its own task.
+func Test_read_uses_calendar_view_for_occurrences(t *testing.T) {
+	standup := func(day int) map[string]any {
+		start := time.Date(2023, 11, day, 9, 0, 0, 0, time.UTC)
+		ev := graphEvent("ACME - Support - standup", start, start.Add(15*min))
+		ev["type"] = "occurrence"
+		return ev
+	}
+	fg := newFakeGraph(t, []map[string]any{standup(6), standup(13), standup(20), standup(27)})
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{})
+
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(tasks))
+	assert.Equal(t, 1, len(fg.queries))
+	assert.Equal(t, novFrom.UTC().Format(time.RFC3339), fg.queries[0].Get("startDateTime"))
+	assert.Equal(t, novTo.UTC().Format(time.RFC3339), fg.queries[0].Get("endDateTime"))
+}
+
+// Returns a UserNotFoundError rather than an empty task list for an unknown user.
+func Test_read_unknown_user(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(3))
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{})
+
+	tasks, err := gs.Read(context.Background(), "nobody@oldgang.net", novFrom, novTo)
+	assert.Equal(t, UserNotFoundError{UserName: "nobody@oldgang.net"}, err)
+	assert.Equal(t, 0, len(tasks))
+	assert.Equal(t, 0, len(fg.queries))
+}
+
+// Refuses to construct a reader without credentials.
+func Test_new_graph_svc_requires_credentials(t *testing.T) {
+	gs, err := NewGraphSvc(TsConfig{Auth: domain.Auth{TenantId: "t", ClientId: "c"}})
+	assert.Nil(t, gs)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
+
+// Categorises failures so that they can be reported distinctly.
+func Test_read_categorises_errors(t *testing.T) {
+	_, err := newTestGraphSvc(t, newFakeGraph(t, nil).URL, TsConfig{}).Read(context.Background(), "nobody@oldgang.net", novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	bad := graphEvent("ProjectX - Doc - bad date", novFrom, novFrom)
+	bad["start"] = map[string]any{"dateTime": "1st November", "timeZone": "UTC"}
+	_, err = newTestGraphSvc(t, newFakeGraph(t, []map[string]any{bad}).URL, TsConfig{}).Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrParse)
+
+	fg := newFakeGraph(t, nil)
+	fg.Close()
+	_, err = newTestGraphSvc(t, fg.URL, TsConfig{}).Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrNetwork)
+}
+
+// Requests events in the mailbox time zone and reports them in that zone,
+// calculating durations correctly across a daylight saving transition.
+func Test_read_uses_mailbox_time_zone(t *testing.T) {
+	// The clocks went back from 02:00 BST to 01:00 GMT on 29th October 2023.
+	ev := map[string]any{
+		"subject": "ProjectX - OnCall - overnight",
+		"start":   map[string]any{"dateTime": "2023-10-29T00:30:00.0000000", "timeZone": "GMT Standard Time"},
+		"end":     map[string]any{"dateTime": "2023-10-29T02:30:00.0000000", "timeZone": "GMT Standard Time"},
+	}
+	fg := newFakeGraph(t, []map[string]any{ev})
+	fg.timeZone = "GMT Standard Time"
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{})
+
+	tasks, err := gs.Read(context.Background(), testUser, time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 10, 31, 23, 59, 59, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, `outlook.timezone="GMT Standard Time"`, fg.prefer[0])
+	// The range is interpreted as wall clock time in the reporting zone: 1st October is BST.
+	assert.Equal(t, "2023-09-30T23:00:00Z", fg.queries[0].Get("startDateTime"))
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "Europe/London", tasks[0].Start.Location().String())
+	assert.Equal(t, 3*hr, tasks[0].Duration)
+}
+
+// A configured time zone overrides the mailbox time zone.
+func Test_read_uses_configured_time_zone(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(1))
+	fg.timeZone = "GMT Standard Time"
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{TimeZone: "America/New_York"})
+
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, `outlook.timezone="America/New_York"`, fg.prefer[0])
+	// The fake returns UTC times regardless and these are converted to the reporting zone.
+	assert.Equal(t, time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC), tasks[0].Start.UTC())
+	assert.Equal(t, 20, tasks[0].Start.Hour())
+	assert.Equal(t, "America/New_York", tasks[0].Start.Location().String())
+}
+
+// Reads the signed in user's calendar with delegated authentication.
+func Test_read_delegated_uses_me(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(2))
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{Auth: domain.Auth{Mode: domain.AuthDevice}})
+
+	tasks, err := gs.Read(context.Background(), "", novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, 1, len(fg.queries))
+}
+
+// Reads each configured calendar, tags each task with its calendar
+// and keeps only one copy of an event that appears in several calendars.
+func Test_read_multiple_calendars(t *testing.T) {
+	at := func(day int) time.Time { return time.Date(2023, 11, day, 10, 0, 0, 0, time.UTC) }
+	meeting := graphEvent("ACME - Support - review", at(2), at(2).Add(hr))
+	meeting["iCalUId"] = "review-1"
+	fg := newFakeGraph(t, []map[string]any{graphEvent("ProjectX - Doc - own", at(1), at(1).Add(hr)), meeting})
+	fg.calendars["u1/Timesheet"] = []map[string]any{graphEvent("ProjectX - Doc - billed", at(3), at(3).Add(hr))}
+	fg.calendars["u2/Calendar"] = []map[string]any{meeting, graphEvent("ACME - Support - rota", at(4), at(4).Add(hr))}
+	cfg := TsConfig{Calendars: ParseCalendarRefs("default, timesheet, team@oldgang.net")}
+	gs := newTestGraphSvc(t, fg.URL, cfg)
+
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	got := []string{}
+	for _, task := range tasks {
+		got = append(got, task.Calendar+" "+task.Desc)
+	}
+	assert.Equal(t, []string{"default own", "default review", "timesheet billed", "team@oldgang.net:default rota"}, got)
+}
+
+// An unknown calendar is a configuration error.
+func Test_read_unknown_calendar(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(1))
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{Calendars: ParseCalendarRefs("Holidays")})
+
+	_, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	assert.ErrorContains(t, err, "Holidays")
+}
+
+func Test_parse_calendar_refs(t *testing.T) {
+	assert.Equal(t, []CalendarRef{
+		{Calendar: "Timesheet"},
+		{Mailbox: "team@oldgang.net"},
+		{Mailbox: "team@oldgang.net", Calendar: "Billable"},
+		{Calendar: "default"},
+	}, ParseCalendarRefs(" Timesheet, team@oldgang.net,team@oldgang.net:Billable,,default"))
+	assert.Equal(t, "team@oldgang.net:default", CalendarRef{Mailbox: "team@oldgang.net"}.String())
+	assert.True(t, CalendarRef{Calendar: "Default"}.IsDefault())
+}
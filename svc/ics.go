@@ -0,0 +1,726 @@
+package svc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// icsSvc implements domain.GraphSvc by reading the events of iCalendar (.ics)
+// files, such as those exported by Thunderbird or Apple Calendar.
+type icsSvc struct {
+	cfg   TsConfig
+	paths []string
+}
+
+// NewIcsSvc returns a GraphSvc that reads events from the .ics files of cfg.IcsFiles.
+func NewIcsSvc(cfg TsConfig) (domain.GraphSvc, error) {
+	if cfg.TimeZone != "" {
+		if _, err := reportingZone(cfg.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Exclude == nil {
+		cfg.Exclude = DefaultExclude
+	}
+	return icsSvc{cfg: cfg, paths: cfg.IcsFiles}, nil
+}
+
+// Read the events of every file that fall within the date range, expanding
+// recurring events into their occurrences. Events are reported in the
+// configured time zone, or the local time zone if there is none, and the same
+// event found in more than one file is only counted once.
+// The user name identifies the attendee whose declined events are excluded.
+func (svc icsSvc) Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+	loc, err := reportingLocation(svc.cfg)
+	if err != nil {
+		return []domain.Task{}, err
+	}
+	fromDate = inZone(fromDate, loc)
+	toDate = inZone(toDate, loc)
+
+	seen := map[string]bool{}
+	tasks := []domain.Task{}
+	for _, path := range svc.paths {
+		if err := ctx.Err(); err != nil {
+			return []domain.Task{}, err
+		}
+		events, err := readIcsFile(path, loc)
+		if err != nil {
+			return []domain.Task{}, err
+		}
+		tasks = append(tasks, icsTasks(svc.cfg, userName, SourceIcs, filepath.Base(path), events, fromDate, toDate, loc, seen)...)
+	}
+	return tasks, nil
+}
+
+// Convert the iCalendar events of a calendar of the named source into the
+// tasks that fall within the date range. Events already seen, perhaps in another calendar, are
+// skipped and those that are new are added to seen.
+func icsTasks(cfg TsConfig, userName string, source string, calendar string, events []*icsEvent, fromDate time.Time, toDate time.Time, loc *time.Location, seen map[string]bool) []domain.Task {
+	tasks := []domain.Task{}
+	for _, occ := range expandIcsEvents(events, fromDate, toDate) {
+		key := occ.ev.uid + "|" + occ.start.instant().UTC().Format(time.RFC3339)
+		if occ.ev.uid == "" {
+			key = occ.ev.summary + "|" + key
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if occ.ev.start.date && cfg.AllDay <= 0 {
+			continue
+		}
+		if occ.ev.excluded(cfg.Exclude, userName) {
+			continue
+		}
+		task := classify(cfg, occ.ev.summary, occ.ev.categories)
+		task.Calendar = calendar
+		task.Source = source
+		if occ.ev.start.date {
+			end := occ.start.wall.Add(occ.ev.length)
+			tasks = append(tasks, workingDayTasks(cfg, task, occ.start.wall, end, fromDate, toDate, loc)...)
+			continue
+		}
+		task.Start = occ.start.instant().In(loc)
+		task.Duration = occ.ev.length
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// An icsZone converts between wall clock times in a time zone and instants.
+// Wall clock times are held as UTC times with the same fields.
+type icsZone interface {
+	instant(wall time.Time) time.Time
+	wall(instant time.Time) time.Time
+}
+
+// A time zone known to the time package.
+type locZone struct {
+	loc *time.Location
+}
+
+func (z locZone) instant(wall time.Time) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, z.loc)
+}
+
+func (z locZone) wall(instant time.Time) time.Time {
+	t := instant.In(z.loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// A time zone defined by a VTIMEZONE component as a set of observances,
+// each of which sets the UTC offset from its onset until the next.
+type vtimezone struct {
+	tzid        string
+	observances []observance
+}
+
+type observance struct {
+	start      time.Time     // The wall clock time of the first onset.
+	rule       *rrule.RRule  // The later onsets, if any.
+	offsetFrom time.Duration // The offset before the onset.
+	offsetTo   time.Duration // The offset after the onset.
+}
+
+// Return the UTC offset in force at a wall clock time.
+func (z vtimezone) offset(wall time.Time) time.Duration {
+	var latest time.Time
+	var offset time.Duration
+	for i, obs := range z.observances {
+		if i == 0 {
+			offset = obs.offsetFrom
+		}
+		onset := obs.start
+		if obs.rule != nil {
+			onset = obs.rule.Before(wall, true)
+		}
+		if onset.IsZero() || onset.After(wall) {
+			continue
+		}
+		if latest.IsZero() || onset.After(latest) {
+			latest = onset
+			offset = obs.offsetTo
+		}
+	}
+	return offset
+}
+
+func (z vtimezone) instant(wall time.Time) time.Time {
+	offset := z.offset(wall)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, time.FixedZone(z.tzid, int(offset.Seconds())))
+}
+
+func (z vtimezone) wall(instant time.Time) time.Time {
+	// The offset depends upon the wall clock time and so we approximate it
+	// with the offset at UTC and then correct it.
+	utc := instant.UTC()
+	return utc.Add(z.offset(utc.Add(z.offset(utc))))
+}
+
+// A date or date-time value of an event.
+type icsTime struct {
+	wall time.Time // The wall clock time, held as UTC.
+	zone icsZone
+	date bool // A date without a time, as used by all-day events.
+}
+
+func (t icsTime) instant() time.Time {
+	return t.zone.instant(t.wall)
+}
+
+// A VEVENT with the properties needed for a timesheet.
+type icsEvent struct {
+	uid          string
+	summary      string
+	categories   []string
+	status       string
+	transp       string
+	class        string
+	start        icsTime
+	length       time.Duration // Wall clock days for all-day events and elapsed time otherwise.
+	rule         string        // The RRULE, if the event recurs.
+	rdates       []icsTime
+	exdates      []icsTime
+	recurrenceId *icsTime          // Set for an occurrence that overrides one of a recurring event.
+	partstat     map[string]string // The participation status of each attendee, by lower case address.
+}
+
+// Apply the exclusion rules to the event. The user is the attendee whose
+// response decides whether the event has been declined or tentatively accepted.
+func (ev icsEvent) excluded(rules []string, userName string) bool {
+	partstat := ev.partstat[strings.ToLower(userName)]
+	for _, rule := range rules {
+		switch rule {
+		case ExcludeDeclined:
+			if partstat == "DECLINED" {
+				return true
+			}
+		case ExcludeCancelled:
+			if ev.status == "CANCELLED" {
+				return true
+			}
+		case ExcludeTentative:
+			if ev.status == "TENTATIVE" || partstat == "TENTATIVE" {
+				return true
+			}
+		case ExcludeFree:
+			if ev.transp == "TRANSPARENT" {
+				return true
+			}
+		case ExcludePrivate:
+			if ev.class == "PRIVATE" || ev.class == "CONFIDENTIAL" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// An occurrence of an event.
+type icsOccurrence struct {
+	ev    *icsEvent
+	start icsTime
+}
+
+// Return the occurrences of the events that overlap the date range.
+// Recurring events are expanded according to their RRULE, RDATE and EXDATE
+// properties, with any occurrences that have been individually changed
+// replaced by their overriding events.
+func expandIcsEvents(events []*icsEvent, fromDate time.Time, toDate time.Time) []icsOccurrence {
+	overridden := map[string]bool{}
+	for _, ev := range events {
+		if ev.recurrenceId != nil {
+			overridden[ev.uid+"|"+ev.recurrenceId.instant().UTC().Format(time.RFC3339)] = true
+		}
+	}
+	occurrences := []icsOccurrence{}
+	for _, ev := range events {
+		starts := []icsTime{ev.start}
+		if ev.recurrenceId == nil && (ev.rule != "" || len(ev.rdates) > 0) {
+			starts = ev.occurrences(fromDate, toDate)
+		}
+		for _, start := range starts {
+			if ev.recurrenceId == nil && overridden[ev.uid+"|"+start.instant().UTC().Format(time.RFC3339)] {
+				continue
+			}
+			occ := icsOccurrence{ev: ev, start: start}
+			if occ.overlaps(fromDate, toDate) {
+				occurrences = append(occurrences, occ)
+			}
+		}
+	}
+	return occurrences
+}
+
+// Return the start of each occurrence of a recurring event near the date range.
+func (ev icsEvent) occurrences(fromDate time.Time, toDate time.Time) []icsTime {
+	// Recurrences are expanded in wall clock time so that an event recurs at
+	// the same local time on either side of a daylight saving transition.
+	after := ev.start.zone.wall(fromDate).Add(-ev.length - 24*time.Hour)
+	before := ev.start.zone.wall(toDate).Add(24 * time.Hour)
+	walls := []time.Time{}
+	if ev.rule == "" {
+		walls = append(walls, ev.start.wall)
+	} else {
+		if r := ev.rrule(); r != nil {
+			walls = append(walls, r.Between(after, before, true)...)
+		}
+	}
+	// An RDATE may be given in another time zone, or in UTC, and so is
+	// converted to a wall clock time in the time zone of the event.
+	for _, rd := range ev.rdates {
+		if rd.date {
+			walls = append(walls, rd.wall)
+		} else {
+			walls = append(walls, ev.start.zone.wall(rd.instant()))
+		}
+	}
+	excluded := map[string]bool{}
+	for _, ex := range ev.exdates {
+		if ex.date {
+			excluded[ex.wall.Format("2006-01-02")] = true
+		} else {
+			excluded[ex.instant().UTC().Format(time.RFC3339)] = true
+		}
+	}
+	starts := []icsTime{}
+	for _, wall := range walls {
+		t := icsTime{wall: wall, zone: ev.start.zone, date: ev.start.date}
+		if excluded[wall.Format("2006-01-02")] || excluded[t.instant().UTC().Format(time.RFC3339)] {
+			continue
+		}
+		starts = append(starts, t)
+	}
+	return starts
+}
+
+// Parse the RRULE of the event, with an UNTIL given in UTC converted to wall clock time.
+// A rule that cannot be parsed is ignored, leaving just the first occurrence.
+func (ev icsEvent) rrule() *rrule.RRule {
+	opt, err := rrule.StrToROptionInLocation(ev.rule, time.UTC)
+	if err != nil {
+		return nil
+	}
+	opt.Dtstart = ev.start.wall
+	if !opt.Until.IsZero() && untilUTC(ev.rule) {
+		opt.Until = ev.start.zone.wall(opt.Until)
+	}
+	r, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+// Report whether the UNTIL of an RRULE is given in UTC rather than in the
+// wall clock time of the event.
+func untilUTC(rule string) bool {
+	for _, part := range strings.Split(rule, ";") {
+		name, value, _ := strings.Cut(part, "=")
+		if strings.EqualFold(strings.TrimSpace(name), "UNTIL") {
+			return strings.HasSuffix(strings.ToUpper(strings.TrimSpace(value)), "Z")
+		}
+	}
+	return false
+}
+
+func (occ icsOccurrence) overlaps(fromDate time.Time, toDate time.Time) bool {
+	if occ.ev.start.date {
+		// All-day events cover whole days, whatever the time zone.
+		from := time.Date(fromDate.Year(), fromDate.Month(), fromDate.Day(), 0, 0, 0, 0, time.UTC)
+		to := time.Date(toDate.Year(), toDate.Month(), toDate.Day(), 0, 0, 0, 0, time.UTC)
+		return !occ.start.wall.After(to) && occ.start.wall.Add(occ.ev.length).After(from)
+	}
+	start := occ.start.instant()
+	return start.Before(toDate) && start.Add(occ.ev.length).After(fromDate)
+}
+
+// A content line of an iCalendar file.
+type icsProp struct {
+	name   string
+	params map[string]string
+	value  string
+	line   int
+}
+
+// A component of an iCalendar file such as a VEVENT or a VTIMEZONE.
+type icsComponent struct {
+	name     string
+	props    []icsProp
+	children []*icsComponent
+}
+
+func (c *icsComponent) prop(name string) (icsProp, bool) {
+	for _, p := range c.props {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return icsProp{}, false
+}
+
+// Read the events of an iCalendar file. Floating times, which have no time
+// zone, are taken to be in the location loc.
+func readIcsFile(path string, loc *time.Location) ([]*icsEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrConfig, err)
+	}
+	defer f.Close()
+	events, err := readIcs(f, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", domain.ErrParse, path, err)
+	}
+	return events, nil
+}
+
+// Read the events of an iCalendar stream.
+func readIcs(r io.Reader, loc *time.Location) ([]*icsEvent, error) {
+	root, err := parseIcs(r)
+	if err != nil {
+		return nil, err
+	}
+	events := []*icsEvent{}
+	for _, cal := range root.children {
+		if cal.name != "VCALENDAR" {
+			continue
+		}
+		zones := map[string]icsZone{}
+		for _, c := range cal.children {
+			if c.name == "VTIMEZONE" {
+				z, err := parseVtimezone(c)
+				if err != nil {
+					return nil, err
+				}
+				zones[z.tzid] = z
+			}
+		}
+		for _, c := range cal.children {
+			if c.name == "VEVENT" {
+				ev, err := parseVevent(c, zones, loc)
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, ev)
+			}
+		}
+	}
+	return events, nil
+}
+
+// Parse an iCalendar stream into a tree of components below an unnamed root.
+// Errors are prefixed with the line number at which they occur.
+func parseIcs(r io.Reader) (*icsComponent, error) {
+	root := &icsComponent{}
+	stack := []*icsComponent{root}
+	props, err := icsLines(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range props {
+		top := stack[len(stack)-1]
+		switch p.name {
+		case "BEGIN":
+			c := &icsComponent{name: strings.ToUpper(p.value)}
+			top.children = append(top.children, c)
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 1 || top.name != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("%d: unexpected END:%s", p.line, p.value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			top.props = append(top.props, p)
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("%d: missing END:%s", props[len(props)-1].line, stack[len(stack)-1].name)
+	}
+	return root, nil
+}
+
+// Read the content lines of an iCalendar stream, unfolding continuation lines.
+func icsLines(r io.Reader) ([]icsProp, error) {
+	props := []icsProp{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var text string
+	var start int
+	flush := func() error {
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		p, err := parseIcsProp(text)
+		if err != nil {
+			return fmt.Errorf("%d: %w", start, err)
+		}
+		p.line = start
+		props = append(props, p)
+		return nil
+	}
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			text += line[1:]
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		text, start = line, n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// Parse a content line of the form name;param=value;param="value":value.
+func parseIcsProp(text string) (icsProp, error) {
+	p := icsProp{params: map[string]string{}}
+	quoted := false
+	colon := -1
+	for i, c := range text {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return p, fmt.Errorf("bad content line '%s'", text)
+	}
+	p.value = text[colon+1:]
+	parts := splitUnquoted(text[:colon], ';')
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		p.params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+	}
+	return p, nil
+}
+
+func splitUnquoted(s string, sep rune) []string {
+	parts := []string{}
+	quoted := false
+	last := 0
+	for i, c := range s {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == sep && !quoted {
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// Undo the escaping of an iCalendar TEXT value.
+var icsTextReplacer = strings.NewReplacer(`\\`, `\`, `\;`, `;`, `\,`, `,`, `\n`, "\n", `\N`, "\n")
+
+func parseVevent(c *icsComponent, zones map[string]icsZone, loc *time.Location) (*icsEvent, error) {
+	ev := &icsEvent{partstat: map[string]string{}}
+	var end *icsTime
+	var duration *time.Duration
+	hasStart := false
+	for _, p := range c.props {
+		var err error
+		switch p.name {
+		case "UID":
+			ev.uid = p.value
+		case "SUMMARY":
+			ev.summary = icsTextReplacer.Replace(p.value)
+		case "CATEGORIES":
+			for _, category := range splitEscaped(p.value, ",", len(p.value)+1) {
+				ev.categories = append(ev.categories, icsTextReplacer.Replace(category))
+			}
+		case "STATUS":
+			ev.status = strings.ToUpper(p.value)
+		case "TRANSP":
+			ev.transp = strings.ToUpper(p.value)
+		case "CLASS":
+			ev.class = strings.ToUpper(p.value)
+		case "DTSTART":
+			ev.start, err = parseIcsTime(p, p.value, zones, loc)
+			hasStart = true
+		case "DTEND":
+			var t icsTime
+			t, err = parseIcsTime(p, p.value, zones, loc)
+			end = &t
+		case "DURATION":
+			var d time.Duration
+			d, err = parseIcsDuration(p.value)
+			duration = &d
+		case "RRULE":
+			ev.rule = p.value
+		case "RDATE", "EXDATE":
+			for _, v := range strings.Split(p.value, ",") {
+				var t icsTime
+				if t, err = parseIcsTime(p, v, zones, loc); err != nil {
+					break
+				}
+				if p.name == "RDATE" {
+					ev.rdates = append(ev.rdates, t)
+				} else {
+					ev.exdates = append(ev.exdates, t)
+				}
+			}
+		case "RECURRENCE-ID":
+			var t icsTime
+			t, err = parseIcsTime(p, p.value, zones, loc)
+			ev.recurrenceId = &t
+		case "ATTENDEE":
+			address := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(p.value, "mailto:"), "MAILTO:"))
+			ev.partstat[address] = strings.ToUpper(p.params["PARTSTAT"])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s: %w", p.line, p.name, err)
+		}
+	}
+	if !hasStart {
+		return nil, fmt.Errorf("event '%s' has no DTSTART", ev.summary)
+	}
+	switch {
+	case end != nil && ev.start.date:
+		ev.length = end.wall.Sub(ev.start.wall)
+	case end != nil:
+		ev.length = end.instant().Sub(ev.start.instant())
+	case duration != nil:
+		ev.length = *duration
+	case ev.start.date:
+		ev.length = 24 * time.Hour
+	}
+	return ev, nil
+}
+
+// Parse a DATE or DATE-TIME value, which may be in UTC, in the time zone
+// named by the TZID parameter, or floating.
+func parseIcsTime(p icsProp, value string, zones map[string]icsZone, loc *time.Location) (icsTime, error) {
+	value = strings.TrimSpace(value)
+	if p.params["VALUE"] == "DATE" || len(value) == 8 {
+		d, err := time.Parse("20060102", value)
+		return icsTime{wall: d, zone: locZone{loc}, date: true}, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return icsTime{wall: t, zone: locZone{time.UTC}}, err
+	}
+	wall, err := time.Parse("20060102T150405", value)
+	if err != nil {
+		return icsTime{}, err
+	}
+	tzid, ok := p.params["TZID"]
+	if !ok {
+		return icsTime{wall: wall, zone: locZone{loc}}, nil
+	}
+	zone, err := icsTimeZone(tzid, zones)
+	return icsTime{wall: wall, zone: zone}, err
+}
+
+// Return the time zone named by a TZID. A VTIMEZONE within the file takes
+// precedence over the IANA and Windows time zones of the same name.
+func icsTimeZone(tzid string, zones map[string]icsZone) (icsZone, error) {
+	if z, ok := zones[tzid]; ok {
+		return z, nil
+	}
+	loc, err := loadTimeZone(strings.TrimPrefix(tzid, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%s'", tzid)
+	}
+	return locZone{loc}, nil
+}
+
+func parseVtimezone(c *icsComponent) (vtimezone, error) {
+	p, _ := c.prop("TZID")
+	z := vtimezone{tzid: p.value}
+	for _, sub := range c.children {
+		if sub.name != "STANDARD" && sub.name != "DAYLIGHT" {
+			continue
+		}
+		var obs observance
+		for _, p := range sub.props {
+			var err error
+			switch p.name {
+			case "DTSTART":
+				obs.start, err = time.Parse("20060102T150405", p.value)
+			case "TZOFFSETFROM":
+				obs.offsetFrom, err = parseUtcOffset(p.value)
+			case "TZOFFSETTO":
+				obs.offsetTo, err = parseUtcOffset(p.value)
+			}
+			if err != nil {
+				return z, fmt.Errorf("%d: %s: %w", p.line, p.name, err)
+			}
+		}
+		if p, ok := sub.prop("RRULE"); ok {
+			opt, err := rrule.StrToROptionInLocation(p.value, time.UTC)
+			if err != nil {
+				return z, fmt.Errorf("%d: RRULE: %w", p.line, err)
+			}
+			opt.Dtstart = obs.start
+			if obs.rule, err = rrule.NewRRule(*opt); err != nil {
+				return z, fmt.Errorf("%d: RRULE: %w", p.line, err)
+			}
+		}
+		z.observances = append(z.observances, obs)
+	}
+	if len(z.observances) == 0 {
+		return z, fmt.Errorf("time zone '%s' has no observances", z.tzid)
+	}
+	return z, nil
+}
+
+// Parse a UTC offset such as +0100 or -0530.
+func parseUtcOffset(s string) (time.Duration, error) {
+	if len(s) < 5 || (s[0] != '+' && s[0] != '-') {
+		return 0, fmt.Errorf("bad UTC offset '%s'", s)
+	}
+	h, err1 := strconv.Atoi(s[1:3])
+	m, err2 := strconv.Atoi(s[3:5])
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("bad UTC offset '%s'", s)
+	}
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	if s[0] == '-' {
+		d = -d
+	}
+	return d, nil
+}
+
+var icsDurationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Parse a duration such as P1D, PT1H30M or P2W.
+func parseIcsDuration(s string) (time.Duration, error) {
+	m := icsDurationPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || s == "P" || s == "PT" {
+		return 0, fmt.Errorf("bad duration '%s'", s)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+2] != "" {
+			n, _ := strconv.Atoi(m[i+2])
+			d += time.Duration(n) * unit
+		}
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
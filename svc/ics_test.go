@@ -0,0 +1,268 @@
+package svc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// Write an iCalendar file holding the given events and return its path.
+func writeIcs(t *testing.T, name string, body string) string {
+	path := filepath.Join(t.TempDir(), name)
+	text := "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//Test//EN\n" + strings.TrimSpace(body) + "\nEND:VCALENDAR\n"
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(text, "\n", "\r\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readIcsTasks(t *testing.T, cfg TsConfig, paths ...string) []domain.Task {
+	cfg.IcsFiles = paths
+	gs, err := NewIcsSvc(cfg)
+	assert.NoError(t, err)
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	return tasks
+}
+
+func Test_ics_reads_events(t *testing.T) {
+	path := writeIcs(t, "work.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:ProjectX - Doc - Write the guide\, part 1
+DTSTART;TZID=Europe/London:20231101T100000
+DTEND;TZID=Europe/London:20231101T113000
+END:VEVENT
+BEGIN:VEVENT
+UID:2
+SUMMARY:ProjectX - Test - A long description that has been folded across
+  two lines
+DTSTART:20231102T090000Z
+DURATION:PT45M
+END:VEVENT
+BEGIN:VEVENT
+UID:3
+SUMMARY:Early Lunch
+DTSTART:20231101T120000
+DTEND:20231101T130000
+END:VEVENT
+BEGIN:VEVENT
+UID:4
+SUMMARY:ProjectX - Doc - Last month
+DTSTART:20231030T090000Z
+DTEND:20231030T100000Z
+END:VEVENT`)
+	tasks := readIcsTasks(t, TsConfig{TimeZone: "Europe/London"}, path)
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, domain.Task{Desc: "Early Lunch", Start: tasks[2].Start, Duration: hr, Calendar: "work.ics", Source: SourceIcs, Unclassified: true}, tasks[2])
+	assert.Equal(t, "Write the guide, part 1", tasks[0].Desc)
+	assert.Equal(t, 10, tasks[0].Start.Hour())
+	assert.Equal(t, 90*min, tasks[0].Duration)
+	assert.Equal(t, "work.ics", tasks[0].Calendar)
+	assert.Equal(t, "A long description that has been folded across two lines", tasks[1].Desc)
+	assert.Equal(t, time.Date(2023, 11, 2, 9, 0, 0, 0, time.UTC), tasks[1].Start.UTC())
+	assert.Equal(t, 45*min, tasks[1].Duration)
+}
+
+// Expands a weekly meeting across the end of daylight saving time, leaving out
+// an excluded date and moving an occurrence that has been rescheduled.
+func Test_ics_expands_recurrences(t *testing.T) {
+	path := writeIcs(t, "recurring.ics", `
+BEGIN:VEVENT
+UID:weekly
+SUMMARY:ACME - Support - standup
+DTSTART;TZID=Europe/London:20231016T090000
+DTEND;TZID=Europe/London:20231016T091500
+RRULE:FREQ=WEEKLY;BYDAY=MO;UNTIL=20231127T090000Z
+EXDATE;TZID=Europe/London:20231113T090000
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly
+RECURRENCE-ID;TZID=Europe/London:20231120T090000
+SUMMARY:ACME - Support - standup
+DTSTART;TZID=Europe/London:20231121T140000
+DTEND;TZID=Europe/London:20231121T141500
+END:VEVENT`)
+	tasks := readIcsTasks(t, TsConfig{TimeZone: "Europe/London"}, path)
+	starts := []string{}
+	for _, task := range tasks {
+		starts = append(starts, task.Start.Format("Mon 02 15:04"))
+		assert.Equal(t, 15*min, task.Duration)
+	}
+	assert.ElementsMatch(t, []string{"Mon 06 09:00", "Tue 21 14:00", "Mon 27 09:00"}, starts)
+}
+
+// Recognises an UNTIL in UTC only from the UNTIL value itself.
+func Test_until_utc(t *testing.T) {
+	assert.True(t, untilUTC("FREQ=WEEKLY;BYDAY=MO;UNTIL=20231127T090000Z"))
+	assert.True(t, untilUTC("until=20231127T090000z;FREQ=DAILY"))
+	assert.False(t, untilUTC("FREQ=WEEKLY;UNTIL=20231127T090000"))
+	assert.False(t, untilUTC("FREQ=WEEKLY;UNTIL=20231127;X-ZONE=Z"))
+	assert.False(t, untilUTC("FREQ=DAILY;COUNT=2"))
+}
+
+// Converts RDATEs in other time zones to the time zone of the event.
+func Test_ics_rdates_in_other_time_zones(t *testing.T) {
+	path := writeIcs(t, "rdates.ics", `
+BEGIN:VEVENT
+UID:review
+SUMMARY:ACME - Support - review
+DTSTART;TZID=Europe/Paris:20231101T090000
+DTEND;TZID=Europe/Paris:20231101T100000
+RRULE:FREQ=DAILY;COUNT=2
+RDATE:20231108T080000Z
+RDATE;TZID=America/New_York:20231115T030000
+END:VEVENT`)
+	tasks := readIcsTasks(t, TsConfig{TimeZone: "UTC"}, path)
+	starts := []string{}
+	for _, task := range tasks {
+		starts = append(starts, task.Start.Format("02 15:04"))
+		assert.Equal(t, hr, task.Duration)
+	}
+	assert.ElementsMatch(t, []string{"01 08:00", "02 08:00", "08 08:00", "15 08:00"}, starts)
+}
+
+// Uses a VTIMEZONE definition for a time zone that is not otherwise known.
+func Test_ics_honours_vtimezone(t *testing.T) {
+	path := writeIcs(t, "custom.ics", `
+BEGIN:VTIMEZONE
+TZID:Custom Eastern
+BEGIN:STANDARD
+DTSTART:19701101T020000
+RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:19700308T020000
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU
+TZOFFSETFROM:-0500
+TZOFFSETTO:-0400
+END:DAYLIGHT
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:1
+SUMMARY:ACME - Support - before
+DTSTART;TZID=Custom Eastern:20231103T100000
+DTEND;TZID=Custom Eastern:20231103T110000
+END:VEVENT
+BEGIN:VEVENT
+UID:2
+SUMMARY:ACME - Support - after
+DTSTART;TZID=Custom Eastern:20231106T100000
+DTEND;TZID=Custom Eastern:20231106T110000
+END:VEVENT`)
+	tasks := readIcsTasks(t, TsConfig{TimeZone: "UTC"}, path)
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, 14, tasks[0].Start.Hour())
+	assert.Equal(t, 15, tasks[1].Start.Hour())
+}
+
+func Test_ics_exclusions_and_all_day_events(t *testing.T) {
+	path := writeIcs(t, "mixed.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:ACME - Meeting - declined
+DTSTART:20231101T090000Z
+DTEND:20231101T100000Z
+ATTENDEE;CN=John Bates;PARTSTAT=DECLINED:mailto:john.bates@oldgang.net
+END:VEVENT
+BEGIN:VEVENT
+UID:2
+SUMMARY:ACME - Meeting - cancelled
+STATUS:CANCELLED
+DTSTART:20231101T100000Z
+DTEND:20231101T110000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:3
+SUMMARY:ACME - Meeting - accepted
+DTSTART:20231101T110000Z
+DTEND:20231101T120000Z
+ATTENDEE;PARTSTAT=ACCEPTED:mailto:john.bates@oldgang.net
+END:VEVENT
+BEGIN:VEVENT
+UID:4
+SUMMARY:ACME - Training - course
+DTSTART;VALUE=DATE:20231102
+DTEND;VALUE=DATE:20231106
+END:VEVENT`)
+	tasks := readIcsTasks(t, TsConfig{TimeZone: "UTC"}, path)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "accepted", tasks[0].Desc)
+
+	tasks = readIcsTasks(t, TsConfig{TimeZone: "UTC", AllDay: 7 * hr}, path)
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, "course", tasks[1].Desc)
+	assert.Equal(t, "2023-11-02", tasks[1].Start.Format("2006-01-02"))
+	assert.Equal(t, "2023-11-03", tasks[2].Start.Format("2006-01-02"))
+	assert.Equal(t, 7*hr, tasks[2].Duration)
+}
+
+// Counts an event that appears in more than one file only once.
+func Test_ics_multiple_files(t *testing.T) {
+	event := `
+BEGIN:VEVENT
+UID:shared
+SUMMARY:ACME - Meeting - review
+DTSTART:20231101T090000Z
+DTEND:20231101T100000Z
+END:VEVENT`
+	a := writeIcs(t, "a.ics", event)
+	b := writeIcs(t, "b.ics", event+`
+BEGIN:VEVENT
+UID:other
+SUMMARY:ACME - Meeting - planning
+DTSTART:20231101T100000Z
+DTEND:20231101T110000Z
+END:VEVENT`)
+	tasks := readIcsTasks(t, TsConfig{TimeZone: "UTC"}, a, b)
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, "a.ics", tasks[0].Calendar)
+	assert.Equal(t, "b.ics", tasks[1].Calendar)
+}
+
+func Test_ics_errors(t *testing.T) {
+	path := writeIcs(t, "bad.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:ACME - Meeting - review
+DTSTART;TZID=Nowhere/Land:20231101T090000
+END:VEVENT`)
+	gs, _ := NewIcsSvc(TsConfig{IcsFiles: []string{path}})
+	_, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrParse)
+	assert.Contains(t, err.Error(), "bad.ics: 7: DTSTART")
+
+	gs, _ = NewIcsSvc(TsConfig{IcsFiles: []string{filepath.Join(t.TempDir(), "missing.ics")}})
+	_, err = gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+
+	_, err = parseIcs(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VCALENDAR\n"))
+	assert.ErrorContains(t, err, "3: unexpected END:VCALENDAR")
+}
+
+func Test_parse_ics_duration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"PT45M":   45 * min,
+		"PT1H30M": 90 * min,
+		"P1D":     24 * hr,
+		"P1W":     7 * 24 * hr,
+		"P1DT2H":  26 * hr,
+		"-PT15M":  -15 * min,
+	} {
+		d, err := parseIcsDuration(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, d, s)
+	}
+	for _, s := range []string{"", "P", "PT", "1H", "PT1X"} {
+		_, err := parseIcsDuration(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,367 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// The task fields that may be read from the columns of a CSV file.
+const (
+	ColumnProject  = "project"
+	ColumnGroup    = "group"
+	ColumnDesc     = "desc"
+	ColumnSubject  = "subject"
+	ColumnStart    = "start"
+	ColumnEnd      = "end"
+	ColumnDuration = "duration"
+)
+
+var csvFields = []string{ColumnProject, ColumnGroup, ColumnDesc, ColumnSubject, ColumnStart, ColumnEnd, ColumnDuration}
+
+// Parse a comma separated column mapping such as "project=Client,desc=Notes,duration=Hours",
+// which names the CSV header of each task field. Fields that are not mapped
+// are read from the column whose header is the field name.
+func ParseCsvColumns(s string) (map[string]string, error) {
+	columns := map[string]string{}
+	for _, m := range strings.Split(s, ",") {
+		if strings.TrimSpace(m) == "" {
+			continue
+		}
+		field, header, ok := strings.Cut(m, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !ok || !slices.Contains(csvFields, field) || strings.TrimSpace(header) == "" {
+			return nil, fmt.Errorf("%w: bad CSV column mapping '%s' (fields are %s)", domain.ErrConfig, strings.TrimSpace(m), strings.Join(csvFields, ", "))
+		}
+		columns[field] = strings.TrimSpace(header)
+	}
+	return columns, nil
+}
+
+// importSvc implements domain.GraphSvc by reading tasks logged outside any
+// calendar from CSV files and JSON files of domain.Task shaped objects.
+type importSvc struct {
+	cfg   TsConfig
+	paths []string
+}
+
+// NewImportSvc returns a GraphSvc that reads tasks from the files of cfg.ImportFiles.
+// The format of each file is given by its .csv or .json extension.
+func NewImportSvc(cfg TsConfig) (domain.GraphSvc, error) {
+	if len(cfg.ImportFiles) == 0 {
+		return nil, fmt.Errorf("%w: no files to import", domain.ErrConfig)
+	}
+	for _, path := range cfg.ImportFiles {
+		if ext := strings.ToLower(filepath.Ext(path)); ext != ".csv" && ext != ".json" {
+			return nil, fmt.Errorf("%w: cannot import '%s': not a .csv or .json file", domain.ErrConfig, path)
+		}
+	}
+	if _, err := reportingLocation(cfg); err != nil {
+		return nil, err
+	}
+	return importSvc{cfg: cfg, paths: cfg.ImportFiles}, nil
+}
+
+// Read the tasks of every file that fall within the date range.
+// Times without a time zone are taken to be in the reporting time zone,
+// which is the configured time zone or otherwise the local time zone.
+// Every invalid row is reported, each with its file name and line number.
+func (svc importSvc) Read(ctx context.Context, userName string, fromDate time.Time, toDate time.Time) ([]domain.Task, error) {
+	loc, err := reportingLocation(svc.cfg)
+	if err != nil {
+		return []domain.Task{}, err
+	}
+	fromDate = inZone(fromDate, loc)
+	toDate = inZone(toDate, loc)
+
+	tasks := []domain.Task{}
+	for _, path := range svc.paths {
+		if err := ctx.Err(); err != nil {
+			return []domain.Task{}, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return []domain.Task{}, fmt.Errorf("%w: %w", domain.ErrConfig, err)
+		}
+		var imported []domain.Task
+		var errs []error
+		if strings.ToLower(filepath.Ext(path)) == ".json" {
+			imported, errs = readJsonTasks(data, svc.cfg.Subject, loc)
+		} else {
+			imported, errs = readCsvTasks(data, svc.cfg.CsvColumns, svc.cfg.Subject, loc)
+		}
+		if len(errs) > 0 {
+			for i, err := range errs {
+				errs[i] = fmt.Errorf("%s:%w", path, err)
+			}
+			return []domain.Task{}, fmt.Errorf("%w: %w", domain.ErrParse, errors.Join(errs...))
+		}
+		for _, task := range imported {
+			if task.Start.Before(toDate) && task.Start.Add(task.Duration).After(fromDate) {
+				task.Start = task.Start.In(loc)
+				task.Calendar = filepath.Base(path)
+				task.Source = SourceImport
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	return tasks, nil
+}
+
+// Read the tasks of a CSV file with a header row. The columns map gives the
+// header of any task field whose column is not named after the field.
+// Errors are prefixed with their line number.
+func readCsvTasks(data []byte, columns map[string]string, subject SubjectParser, loc *time.Location) ([]domain.Task, []error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return []domain.Task{}, nil
+	}
+	if err != nil {
+		return nil, []error{csvError(err)}
+	}
+	index := map[string]int{}
+	for _, field := range csvFields {
+		name := field
+		if h, ok := columns[field]; ok {
+			name = h
+		}
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				index[field] = i
+			}
+		}
+	}
+	required := []string{ColumnProject, ColumnDesc, ColumnStart}
+	if _, ok := index[ColumnSubject]; ok {
+		required = []string{ColumnStart}
+	}
+	for _, field := range required {
+		if _, ok := index[field]; !ok {
+			return nil, []error{fmt.Errorf("1: no column for the task %s", field)}
+		}
+	}
+	if _, ok := index[ColumnEnd]; !ok {
+		if _, ok := index[ColumnDuration]; !ok {
+			return nil, []error{fmt.Errorf("1: no column for the task end or duration")}
+		}
+	}
+
+	tasks := []domain.Task{}
+	errs := []error{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, csvError(err))
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		value := func(field string) string {
+			if i, ok := index[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row := importRow{
+			Project:  value(ColumnProject),
+			Group:    value(ColumnGroup),
+			Desc:     value(ColumnDesc),
+			Subject:  value(ColumnSubject),
+			Start:    value(ColumnStart),
+			End:      value(ColumnEnd),
+			Duration: value(ColumnDuration),
+		}
+		task, err := importedTask(row, subject, loc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%d: %w", line, err))
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, errs
+}
+
+func csvError(err error) error {
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		return fmt.Errorf("%d: %w", perr.Line, perr.Err)
+	}
+	return err
+}
+
+// A task as it appears in a JSON file: the fields of domain.Task, with an
+// optional End in place of the Duration. The Duration may be a number of
+// nanoseconds, as json.Marshal writes a time.Duration, or a string.
+// A Subject may be given in place of the Project, Group, Subgroups and Desc.
+// Tags are added to any annotations at the end of the Desc or Subject.
+type jsonTask struct {
+	Project   string
+	Group     string
+	Subgroups []string
+	Desc      string
+	Subject   string
+	Start     string
+	End       string
+	Duration  json.RawMessage
+	Tags      map[string]string
+}
+
+// Read the tasks of a JSON array. Errors are prefixed with the line number
+// at which the offending task begins.
+func readJsonTasks(data []byte, subject SubjectParser, loc *time.Location) ([]domain.Task, []error) {
+	lineAt := func(offset int64) int {
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, []error{fmt.Errorf("%d: expected an array of tasks", lineAt(dec.InputOffset()))}
+	}
+	tasks := []domain.Task{}
+	errs := []error{}
+	for dec.More() {
+		// Skip the whitespace and comma before the task to find the line on which it starts.
+		offset := dec.InputOffset()
+		for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+			offset++
+		}
+		line := lineAt(offset)
+		var jt jsonTask
+		if err := dec.Decode(&jt); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				errs = append(errs, fmt.Errorf("%d: %w", line, err))
+				continue
+			}
+			return nil, append(errs, fmt.Errorf("%d: %w", line, err))
+		}
+		duration := ""
+		if len(jt.Duration) > 0 && string(jt.Duration) != "null" {
+			if err := json.Unmarshal(jt.Duration, &duration); err != nil {
+				// Not a string and so a number of nanoseconds.
+				var ns int64
+				if err := json.Unmarshal(jt.Duration, &ns); err != nil {
+					errs = append(errs, fmt.Errorf("%d: bad duration %s", line, jt.Duration))
+					continue
+				}
+				duration = time.Duration(ns).String()
+			}
+		}
+		row := importRow{Project: jt.Project, Group: jt.Group, Subgroups: jt.Subgroups, Desc: jt.Desc, Subject: jt.Subject, Start: jt.Start, End: jt.End, Duration: duration, Tags: jt.Tags}
+		task, err := importedTask(row, subject, loc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%d: %w", line, err))
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, errs
+}
+
+// The fields of an imported task as they are written in a file.
+type importRow struct {
+	Project, Group, Desc, Subject string
+	Subgroups                     []string
+	Start, End, Duration          string
+	Tags                          map[string]string
+}
+
+// Validate the fields of an imported task and construct it.
+// Either the end or the duration must be given. A subject is split by the
+// subject parser, as an event's is, when there is no project or description.
+// Any tags given explicitly are added to those at the end of the description.
+func importedTask(row importRow, subject SubjectParser, loc *time.Location) (domain.Task, error) {
+	task := domain.Task{Project: row.Project, Group: row.Group, Subgroups: row.Subgroups}
+	task.Desc, task.Tags = splitTags(row.Desc)
+	if row.Project == "" && row.Desc == "" && row.Subject != "" {
+		var ok bool
+		if task, ok = subject.Parse(row.Subject); !ok {
+			return domain.Task{}, fmt.Errorf("subject '%s' does not name a project", row.Subject)
+		}
+	}
+	for name, value := range row.Tags {
+		if task.Tags == nil {
+			task.Tags = map[string]string{}
+		}
+		task.Tags[strings.ToLower(name)] = value
+	}
+	if task.Project == "" {
+		return domain.Task{}, errors.New("missing project")
+	}
+	if task.Desc == "" {
+		return domain.Task{}, errors.New("missing description")
+	}
+	if row.Start == "" {
+		return domain.Task{}, errors.New("missing start")
+	}
+	var err error
+	if task.Start, err = parseImportTime(row.Start, loc); err != nil {
+		return domain.Task{}, fmt.Errorf("bad start '%s'", row.Start)
+	}
+	switch {
+	case row.End != "" && row.Duration != "":
+		return domain.Task{}, errors.New("both end and duration given")
+	case row.End != "":
+		t, err := parseImportTime(row.End, loc)
+		if err != nil {
+			return domain.Task{}, fmt.Errorf("bad end '%s'", row.End)
+		}
+		task.Duration = t.Sub(task.Start)
+	case row.Duration != "":
+		if task.Duration, err = parseImportDuration(row.Duration); err != nil {
+			return domain.Task{}, fmt.Errorf("bad duration '%s'", row.Duration)
+		}
+	default:
+		return domain.Task{}, errors.New("missing end or duration")
+	}
+	if task.Duration <= 0 {
+		return domain.Task{}, errors.New("the task must end after it starts")
+	}
+	return task, nil
+}
+
+// The formats accepted for imported times. Those without a time zone are in
+// the reporting time zone.
+var importTimeFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+func parseImportTime(s string, loc *time.Location) (time.Time, error) {
+	for _, format := range importTimeFormats {
+		if t, err := time.ParseInLocation(format, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time '%s'", s)
+}
+
+// Parse a duration written as a Go duration ("1h30m"), as hours and minutes
+// ("1:30") or as decimal hours ("1.5").
+func parseImportDuration(s string) (time.Duration, error) {
+	if h, m, ok := strings.Cut(s, ":"); ok {
+		hours, err1 := strconv.Atoi(h)
+		minutes, err2 := strconv.Atoi(m)
+		if err1 != nil || err2 != nil || minutes < 0 || minutes >= 60 {
+			return 0, fmt.Errorf("bad duration '%s'", s)
+		}
+		return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+	}
+	if hours, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(hours * float64(time.Hour)).Round(time.Second), nil
+	}
+	return time.ParseDuration(s)
+}
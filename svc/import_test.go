@@ -0,0 +1,158 @@
+package svc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+func writeFile(t *testing.T, name string, text string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readImported(t *testing.T, cfg TsConfig) ([]domain.Task, error) {
+	gs, err := NewImportSvc(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return gs.Read(context.Background(), testUser, novFrom, novTo)
+}
+
+func Test_import_csv(t *testing.T) {
+	path := writeFile(t, "log.csv", `project,group,desc,start,end,duration
+ProjectX,Doc,"Write the guide, part 2",2023-11-03 09:00,2023-11-03 10:30,
+ProjectX,,Phone call,2023-11-03T14:00:00Z,,0:20
+ProjectX,Doc,Last month,2023-10-31 09:00,,1h
+`)
+	tasks, err := readImported(t, TsConfig{TimeZone: "Europe/Berlin", ImportFiles: []string{path}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(tasks))
+	assert.Equal(t, domain.Task{Project: "ProjectX", Group: "Doc", Desc: "Write the guide, part 2", Start: tasks[0].Start, Duration: 90 * min, Calendar: "log.csv", Source: SourceImport}, tasks[0])
+	assert.Equal(t, "2023-11-03T09:00:00+01:00", tasks[0].Start.Format(time.RFC3339))
+	assert.Equal(t, "", tasks[1].Group)
+	assert.Equal(t, 20*min, tasks[1].Duration)
+	assert.Equal(t, 15, tasks[1].Start.Hour())
+}
+
+// Reads columns with other headers through the column mapping.
+func Test_import_csv_column_mapping(t *testing.T) {
+	path := writeFile(t, "export.csv", `Client,Task,When,Hours,Area
+ACME,Support,2023-11-06 09:00,1.5,Ops
+`)
+	columns, err := ParseCsvColumns("project=Client, desc=Task, start=When, duration=Hours, group=Area")
+	assert.NoError(t, err)
+	tasks, err := readImported(t, TsConfig{TimeZone: "UTC", ImportFiles: []string{path}, CsvColumns: columns})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "ACME", tasks[0].Project)
+	assert.Equal(t, "Ops", tasks[0].Group)
+	assert.Equal(t, 90*min, tasks[0].Duration)
+
+	_, err = ParseCsvColumns("client=Client")
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
+
+// Reports every invalid row with its line number.
+func Test_import_csv_errors(t *testing.T) {
+	path := writeFile(t, "bad.csv", `project,group,desc,start,duration
+ProjectX,Doc,Fine,2023-11-03 09:00,1h
+,Doc,No project,2023-11-03 09:00,1h
+ProjectX,Doc,Bad start,3rd November,1h
+ProjectX,Doc,"Multi
+line",2023-11-03 09:00,
+ProjectX,Doc,Negative,2023-11-03 09:00,-1h
+`)
+	_, err := readImported(t, TsConfig{ImportFiles: []string{path}})
+	assert.ErrorIs(t, err, domain.ErrParse)
+	assert.ErrorContains(t, err, "bad.csv:3: missing project")
+	assert.ErrorContains(t, err, "bad.csv:4: bad start '3rd November'")
+	assert.ErrorContains(t, err, "bad.csv:5: missing end or duration")
+	assert.ErrorContains(t, err, "bad.csv:7: the task must end after it starts")
+	assert.NotContains(t, err.Error(), "bad.csv:2")
+
+	path = writeFile(t, "columns.csv", "project,desc,duration\nProjectX,Doc,1h\n")
+	_, err = readImported(t, TsConfig{ImportFiles: []string{path}})
+	assert.ErrorContains(t, err, "columns.csv:1: no column for the task start")
+}
+
+func Test_import_json(t *testing.T) {
+	path := writeFile(t, "log.json", `[
+  {"Project": "ProjectX", "Group": "Doc", "Desc": "Guide", "Start": "2023-11-03T09:00:00Z", "Duration": 5400000000000},
+  {"project": "ACME", "desc": "Support", "start": "2023-11-06 09:00", "end": "2023-11-06 09:45"},
+  {"Project": "ACME", "Desc": "Support #billable", "Start": "2023-11-07T09:00:00Z", "Duration": "1:15", "Tags": {"Ticket": "JIRA-123"}}
+]`)
+	tasks, err := readImported(t, TsConfig{TimeZone: "UTC", ImportFiles: []string{path}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, "Support", tasks[2].Desc)
+	assert.Equal(t, map[string]string{"billable": "", "ticket": "JIRA-123"}, tasks[2].Tags)
+	assert.Equal(t, 90*min, tasks[0].Duration)
+	assert.Equal(t, 45*min, tasks[1].Duration)
+	assert.Equal(t, 75*min, tasks[2].Duration)
+	assert.Equal(t, "log.json", tasks[2].Calendar)
+}
+
+func Test_import_json_errors(t *testing.T) {
+	path := writeFile(t, "bad.json", `[
+  {"Project": "ProjectX", "Desc": "Guide", "Start": "2023-11-03T09:00:00Z", "Duration": "1h"},
+  {"Project": "ProjectX", "Desc": "Guide", "Start": "2023-11-03T09:00:00Z"},
+  {"Project": 42, "Desc": "Guide", "Start": "2023-11-03T09:00:00Z", "Duration": "1h"},
+
+  {"Project": "ProjectX", "Desc": "Guide", "Start": "2023-11-03T09:00:00Z", "Duration": true}
+]`)
+	_, err := readImported(t, TsConfig{ImportFiles: []string{path}})
+	assert.ErrorIs(t, err, domain.ErrParse)
+	assert.ErrorContains(t, err, "bad.json:3: missing end or duration")
+	assert.ErrorContains(t, err, "bad.json:4: ")
+	assert.ErrorContains(t, err, "bad.json:6: bad duration true")
+
+	path = writeFile(t, "object.json", `{"Project": "ProjectX"}`)
+	_, err = readImported(t, TsConfig{ImportFiles: []string{path}})
+	assert.ErrorContains(t, err, "object.json:1: expected an array of tasks")
+
+	_, err = NewImportSvc(TsConfig{ImportFiles: []string{"tasks.txt"}})
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
+
+// Adds imported tasks to those read from Microsoft Graph.
+func Test_import_merges_with_graph(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(4))
+	path := writeFile(t, "log.csv", "project,desc,start,duration\nProjectX,Phone call,2023-11-03 09:00,1h\n")
+	cfg := TsConfig{TimeZone: "UTC", ImportFiles: []string{path}}
+	merged := newCompositeSvc([]namedSource{{SourceGraph, newTestGraphSvc(t, fg.URL, cfg)}, {SourceImport, importSvc{cfg: cfg, paths: cfg.ImportFiles}}}, DuplicatesFirst)
+	tasks, err := merged.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(tasks))
+	assert.Equal(t, "Phone call", tasks[4].Desc)
+	assert.Equal(t, SourceImport, tasks[4].Source)
+	assert.Equal(t, 4, merged.Stats().Events)
+
+	// NewServices merges imported tasks with the configured source.
+	services, err := NewServices(TsConfig{Replay: "testdata/november.json", ImportFiles: []string{path}})
+	assert.NoError(t, err)
+	assert.IsType(t, compositeSvc{}, services.Graph)
+	services, err = NewServices(TsConfig{Sources: []string{SourceImport}, ImportFiles: []string{path}})
+	assert.NoError(t, err)
+	assert.IsType(t, importSvc{}, services.Graph)
+}
+
+func Test_parse_import_duration(t *testing.T) {
+	for s, want := range map[string]time.Duration{"1h30m": 90 * min, "1:30": 90 * min, "1.5": 90 * min, "0.25": 15 * min, "2": 2 * hr} {
+		d, err := parseImportDuration(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, d, s)
+	}
+	for _, s := range []string{"", "1:75", "an hour"} {
+		_, err := parseImportDuration(s)
+		assert.Error(t, err, s)
+	}
+}
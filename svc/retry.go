@@ -0,0 +1,102 @@
+package svc
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryConfig controls how requests to Microsoft Graph are retried
+// when the service is throttling us or is temporarily unavailable.
+type RetryConfig struct {
+	MaxRetries int           // Number of retries after the first attempt.
+	BaseDelay  time.Duration // Delay before the first retry; doubled for each subsequent retry.
+	MaxDelay   time.Duration // Upper bound on any single delay, including a Retry-After delay.
+}
+
+// The retry configuration used when none is given.
+var DefaultRetry = RetryConfig{
+	MaxRetries: 5,
+	BaseDelay:  time.Second,
+	MaxDelay:   time.Minute,
+}
+
+// retryTransport implements http.RoundTripper.
+// It retries requests that receive a 429, 503 or 504 response, waiting for
+// the period given by the Retry-After header or, failing that, for an
+// exponentially increasing delay. The wait is abandoned if the request's
+// context is cancelled. A request with a body is retried as a clone with a
+// fresh copy of the body, leaving the caller's request unmodified.
+type retryTransport struct {
+	next http.RoundTripper
+	cfg  RetryConfig
+}
+
+func (rt retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	try := req
+	for attempt := 0; ; attempt++ {
+		resp, err := rt.next.RoundTrip(try)
+		if err != nil || !retriable(resp.StatusCode) || attempt >= rt.cfg.MaxRetries {
+			return resp, err
+		}
+		delay := rt.delay(resp, attempt)
+
+		// Drain the body so that the connection may be reused.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try = req.Clone(req.Context())
+			try.Body = body
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Return the delay before the retry that follows the given attempt.
+func (rt retryTransport) delay(resp *http.Response, attempt int) time.Duration {
+	delay, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		delay = time.Duration(float64(rt.cfg.BaseDelay) * math.Pow(2, float64(attempt)))
+	}
+	if rt.cfg.MaxDelay > 0 && delay > rt.cfg.MaxDelay {
+		delay = rt.cfg.MaxDelay
+	}
+	return delay
+}
+
+func retriable(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// Interpret a Retry-After header value, which may be either
+// a number of seconds or an HTTP date, relative to now.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,119 @@
+package svc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// Retries a throttled calendar request until it succeeds.
+func Test_read_retries_when_throttled(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(3))
+	fg.throttle = 2
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{Retry: DefaultRetry})
+
+	tasks, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(tasks))
+	assert.Equal(t, 3, len(fg.queries))
+}
+
+// Gives up once the retries are exhausted.
+func Test_read_gives_up_after_max_retries(t *testing.T) {
+	fg := newFakeGraph(t, quarterHours(3))
+	fg.throttle = 3
+	gs := newTestGraphSvc(t, fg.URL, TsConfig{Retry: RetryConfig{MaxRetries: 2}})
+
+	_, err := gs.Read(context.Background(), testUser, novFrom, novTo)
+	assert.ErrorIs(t, err, domain.ErrNetwork)
+	assert.Equal(t, 3, len(fg.queries))
+}
+
+// Backs off exponentially when there is no Retry-After header.
+func Test_retry_transport_backs_off_exponentially(t *testing.T) {
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		if len(times) <= 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	client := http.Client{Transport: retryTransport{http.DefaultTransport, RetryConfig{MaxRetries: 3, BaseDelay: 10 * time.Millisecond}}}
+
+	resp, err := client.Get(srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 4, len(times))
+	for i, want := range []time.Duration{10, 20, 40} {
+		assert.GreaterOrEqual(t, times[i+1].Sub(times[i]), want*time.Millisecond)
+	}
+}
+
+// Resends the body of a retried request without modifying the request.
+func Test_retry_transport_resends_body(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	rt := retryTransport{http.DefaultTransport, RetryConfig{MaxRetries: 1, BaseDelay: time.Millisecond}}
+	req, _ := http.NewRequest("REPORT", srv.URL, strings.NewReader("<calendar-query/>"))
+	body := req.Body
+
+	resp, err := rt.RoundTrip(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"<calendar-query/>", "<calendar-query/>"}, bodies)
+	assert.Equal(t, body, req.Body)
+}
+
+// Stops waiting to retry when the context is cancelled.
+func Test_retry_transport_honours_cancellation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	client := http.Client{Transport: retryTransport{http.DefaultTransport, DefaultRetry}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+	start := time.Now()
+	_, err := client.Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func Test_retry_after(t *testing.T) {
+	now := time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"7", 7 * time.Second, true},
+		{"Wed, 01 Nov 2023 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 Nov 2023 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.value, now)
+		assert.Equal(t, tt.want, got, tt.value)
+		assert.Equal(t, tt.ok, ok, tt.value)
+	}
+}
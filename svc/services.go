@@ -1,13 +1,108 @@
 package svc
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/vextasy/Timesheet_go/domain"
 )
 
-func NewServices(cfg TsConfig) domain.TimesheetServices {
+// The sources from which events may be read.
+const (
+	SourceGraph  = "graph"  // Microsoft Graph.
+	SourceReplay = "replay" // A fixture file recorded from Microsoft Graph.
+	SourceIcs    = "ics"    // iCalendar files.
+	SourceCalDAV = "caldav" // CalDAV calendars.
+	SourceImport = "import" // Files of tasks to import.
+)
+
+// The constructor of the GraphSvc for each source of events.
+var sources = map[string]func(TsConfig) (domain.GraphSvc, error){
+	SourceGraph:  newGraphReader,
+	SourceReplay: func(cfg TsConfig) (domain.GraphSvc, error) { return NewReplaySvc(cfg, cfg.Replay) },
+	SourceIcs:    NewIcsSvc,
+	SourceCalDAV: NewCalDAVSvc,
+	SourceImport: NewImportSvc,
+}
+
+func NewServices(cfg TsConfig) (domain.TimesheetServices, error) {
+	graph, err := newGraphSource(cfg)
+	if err != nil {
+		return domain.TimesheetServices{}, err
+	}
 	return domain.TimesheetServices{
-		Graph: NewGraphSvc(cfg.Auth),
+		Graph: graph,
 		Cal:   NewCalendarSvc(),
 		Dump:  NewDumpSvc(cfg),
+	}, nil
+}
+
+// Return the GraphSvc for the configured sources of events.
+// Several sources are read together by a compositeSvc.
+func newGraphSource(cfg TsConfig) (domain.GraphSvc, error) {
+	names := SourceNames(cfg)
+	if cfg.Record != "" && (len(names) > 1 || names[0] != SourceGraph) {
+		return nil, fmt.Errorf("%w: only events read from Microsoft Graph can be recorded", domain.ErrConfig)
+	}
+	named := []namedSource{}
+	for _, name := range names {
+		newSource, ok := sources[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown source '%s'", domain.ErrConfig, name)
+		}
+		graph, err := newSource(cfg)
+		if err != nil {
+			return nil, err
+		}
+		named = append(named, namedSource{name: name, graph: graph})
+	}
+	if len(named) == 1 {
+		return named[0].graph, nil
+	}
+	policy, err := ParseDuplicates(cfg.Duplicates)
+	if err != nil {
+		return nil, err
+	}
+	return newCompositeSvc(named, policy), nil
+}
+
+// SourceNames returns the names of the configured sources of events.
+// Unless Sources are given there is one source: the one for which there is
+// configuration or otherwise Microsoft Graph. Any files to import are added
+// as a further source.
+func SourceNames(cfg TsConfig) []string {
+	names := []string{}
+	for _, name := range cfg.Sources {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		switch {
+		case cfg.Replay != "":
+			names = append(names, SourceReplay)
+		case len(cfg.IcsFiles) > 0:
+			names = append(names, SourceIcs)
+		case len(cfg.CalDAV.URLs) > 0:
+			names = append(names, SourceCalDAV)
+		default:
+			names = append(names, SourceGraph)
+		}
+	}
+	if len(cfg.ImportFiles) > 0 && !slices.Contains(names, SourceImport) {
+		names = append(names, SourceImport)
+	}
+	return names
+}
+
+// Return the GraphSvc that reads from Microsoft Graph, recording if asked to.
+func newGraphReader(cfg TsConfig) (domain.GraphSvc, error) {
+	if cfg.UserName == "" && !cfg.Auth.Delegated() {
+		return nil, fmt.Errorf("%w: a user name is required", domain.ErrConfig)
+	}
+	if cfg.Record != "" {
+		return NewRecordSvc(cfg, cfg.Record)
 	}
+	return NewGraphSvc(cfg)
 }
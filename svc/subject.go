@@ -0,0 +1,180 @@
+package svc
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// The names of the groups of a subject pattern. Further levels of
+// the hierarchy, below the group, are named "group2", "group3" and so on.
+const (
+	SubjectProject = "project"
+	SubjectGroup   = "group"
+	SubjectDesc    = "desc"
+)
+
+// The number of levels of the task hierarchy, including the project and the
+// description, unless more are configured.
+const DefaultLevels = 3
+
+// proj (- group) - description
+var defaultSubjectPattern = levelsPattern(DefaultLevels)
+
+// Return the pattern that matches "Project - Group - ... - Description"
+// with up to levels parts, where all but the description are single words.
+// Missing levels are the innermost groups.
+func levelsPattern(levels int) *regexp.Regexp {
+	groups := ""
+	for i := levels - 2; i >= 1; i-- {
+		groups = fmt.Sprintf(`(?:\s*-\s*(?P<%s>[\w/]+)%s)?`, subjectGroupName(i), groups)
+	}
+	return regexp.MustCompile(`^\s*(?P<project>[\w/]+)` + groups + `\s*-\s*(?P<desc>.*)`)
+}
+
+// Return the name of the pattern group for the nth level below the project.
+func subjectGroupName(n int) string {
+	if n == 1 {
+		return SubjectGroup
+	}
+	return fmt.Sprintf("%s%d", SubjectGroup, n)
+}
+
+// A SubjectParser classifies an event by its subject, which holds the
+// project, optional group and description of a task. The subject is either
+// matched by a regular expression with named groups or split by a separator.
+// The zero SubjectParser matches "Project - Group - Description" and
+// "Project - Description" where the project and group are single words.
+type SubjectParser struct {
+	pattern   *regexp.Regexp
+	separator string
+	levels    int // The most parts into which a subject is split by the separator.
+}
+
+// Return the SubjectParser for a subject pattern or a separator, at most one
+// of which may be given, and for a hierarchy with the given number of levels,
+// or DefaultLevels if zero. The levels of a pattern are given by its groups.
+// The default parser is returned if neither a pattern nor a separator is given.
+func ParseSubject(pattern string, separator string, levels int) (SubjectParser, error) {
+	if levels == 0 {
+		levels = DefaultLevels
+	}
+	switch {
+	case levels < 2:
+		return SubjectParser{}, fmt.Errorf("%w: a subject must have at least 2 levels", domain.ErrConfig)
+	case pattern != "" && separator != "":
+		return SubjectParser{}, fmt.Errorf("%w: give either a subject pattern or a separator, not both", domain.ErrConfig)
+	case pattern != "":
+		return NewSubjectPattern(pattern)
+	case separator != "":
+		return NewSubjectSeparator(separator, levels)
+	case levels != DefaultLevels:
+		return SubjectParser{pattern: levelsPattern(levels)}, nil
+	}
+	return SubjectParser{}, nil
+}
+
+// Return a SubjectParser that matches subjects with a regular expression.
+// The expression must name groups "project" and "desc" and may name a group
+// "group" and groups "group2", "group3" and so on for deeper levels.
+func NewSubjectPattern(expr string) (SubjectParser, error) {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return SubjectParser{}, fmt.Errorf("%w: bad subject pattern: %w", domain.ErrConfig, err)
+	}
+	for _, name := range []string{SubjectProject, SubjectDesc} {
+		if pattern.SubexpIndex(name) < 0 {
+			return SubjectParser{}, fmt.Errorf("%w: the subject pattern has no group named '%s'", domain.ErrConfig, name)
+		}
+	}
+	return SubjectParser{pattern: pattern}, nil
+}
+
+// Return a SubjectParser that splits subjects, such as "Acme-UK | Dev | Release",
+// at a separator into at most levels parts, or DefaultLevels if zero.
+// Spaces around each part are ignored and a separator within a name is
+// escaped with a backslash, as is a backslash itself. The first part is the
+// project and the last the description, which is everything after the last
+// separator that divides the levels. A subject with fewer parts than levels
+// has no innermost groups.
+func NewSubjectSeparator(separator string, levels int) (SubjectParser, error) {
+	if strings.TrimSpace(separator) == "" || strings.Contains(separator, `\`) {
+		return SubjectParser{}, fmt.Errorf("%w: bad subject separator '%s'", domain.ErrConfig, separator)
+	}
+	if levels == 0 {
+		levels = DefaultLevels
+	}
+	return SubjectParser{separator: separator, levels: levels}, nil
+}
+
+// Parse returns a task with the project, groups, description and tags of a subject.
+// The boolean result is false if the subject does not match.
+func (p SubjectParser) Parse(subject string) (domain.Task, bool) {
+	var project, desc string
+	var groups []string
+	if p.separator != "" {
+		parts := splitEscaped(subject, p.separator, p.levels)
+		if len(parts) < 2 {
+			return domain.Task{}, false
+		}
+		project, groups, desc = parts[0], parts[1:len(parts)-1], parts[len(parts)-1]
+	} else {
+		pattern := p.pattern
+		if pattern == nil {
+			pattern = defaultSubjectPattern
+		}
+		matches := pattern.FindStringSubmatch(subject)
+		if matches == nil {
+			return domain.Task{}, false
+		}
+		project = matches[pattern.SubexpIndex(SubjectProject)]
+		desc = matches[pattern.SubexpIndex(SubjectDesc)]
+		for n := 1; pattern.SubexpIndex(subjectGroupName(n)) >= 0; n++ {
+			groups = append(groups, matches[pattern.SubexpIndex(subjectGroupName(n))])
+		}
+	}
+	if project == "" {
+		return domain.Task{}, false
+	}
+	// Leave out the missing innermost groups.
+	for len(groups) > 0 && groups[len(groups)-1] == "" {
+		groups = groups[:len(groups)-1]
+	}
+	task := domain.Task{Project: project}
+	task.Desc, task.Tags = splitTags(desc)
+	if len(groups) > 0 {
+		task.Group = groups[0]
+	}
+	if len(groups) > 1 {
+		task.Subgroups = slices.Clone(groups[1:])
+	}
+	return task, true
+}
+
+// Split s into at most n trimmed parts at each unescaped separator, removing
+// the escapes. The last part is the remainder of s, with its escapes removed.
+func splitEscaped(s string, separator string, n int) []string {
+	parts := []string{}
+	var part strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && strings.HasPrefix(s[i+1:], separator):
+			part.WriteString(separator)
+			i += 1 + len(separator)
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '\\':
+			part.WriteByte('\\')
+			i += 2
+		case len(parts) < n-1 && strings.HasPrefix(s[i:], separator):
+			parts = append(parts, strings.TrimSpace(part.String()))
+			part.Reset()
+			i += len(separator)
+		default:
+			part.WriteByte(s[i])
+			i++
+		}
+	}
+	return append(parts, strings.TrimSpace(part.String()))
+}
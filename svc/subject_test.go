@@ -0,0 +1,200 @@
+package svc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+func parsed(project string, group string, desc string) domain.Task {
+	return domain.Task{Project: project, Group: group, Desc: desc}
+}
+
+func Test_default_subject_parser(t *testing.T) {
+	var p SubjectParser
+	for subject, want := range map[string]domain.Task{
+		"ProjectX - Doc - Write the guide": parsed("ProjectX", "Doc", "Write the guide"),
+		"ProjectX - Phone call":            parsed("ProjectX", "", "Phone call"),
+		"  A/B-Dev-Fix - the build":        parsed("A/B", "Dev", "Fix - the build"),
+	} {
+		task, ok := p.Parse(subject)
+		assert.True(t, ok, subject)
+		assert.Equal(t, want, task, subject)
+	}
+	for _, subject := range []string{"Lunch", "Acme UK - Dev - Release", ""} {
+		_, ok := p.Parse(subject)
+		assert.False(t, ok, subject)
+	}
+}
+
+func Test_subject_pattern(t *testing.T) {
+	p, err := NewSubjectPattern(`^\[(?P<project>[^\]]+)\]\s*(?:(?P<group>\w+):)?\s*(?P<desc>.+)$`)
+	assert.NoError(t, err)
+	task, ok := p.Parse("[Acme UK] Dev: Release 1.2")
+	assert.True(t, ok)
+	assert.Equal(t, parsed("Acme UK", "Dev", "Release 1.2"), task)
+	task, ok = p.Parse("[Acme.io] Planning")
+	assert.True(t, ok)
+	assert.Equal(t, parsed("Acme.io", "", "Planning"), task)
+	_, ok = p.Parse("Acme - Planning")
+	assert.False(t, ok)
+
+	// The group is optional.
+	p, err = NewSubjectPattern(`^(?P<project>.+?):(?P<desc>.*)$`)
+	assert.NoError(t, err)
+	task, ok = p.Parse("Acme-UK:Support")
+	assert.True(t, ok)
+	assert.Equal(t, parsed("Acme-UK", "", "Support"), task)
+
+	_, err = NewSubjectPattern(`^(?P<project>\w+) - (.*)$`)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	_, err = NewSubjectPattern(`^(?P<project>\w+`)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
+
+func Test_subject_separator(t *testing.T) {
+	p, err := NewSubjectSeparator("|", 0)
+	assert.NoError(t, err)
+	for subject, want := range map[string]domain.Task{
+		"Acme-UK | Dev | Release 1.2":   parsed("Acme-UK", "Dev", "Release 1.2"),
+		"Acme-UK | Support":             parsed("Acme-UK", "", "Support"),
+		"Acme-UK | Dev | Fix a | b":     parsed("Acme-UK", "Dev", "Fix a | b"),
+		`A\|B Ltd | Dev \\ Ops | Build`: parsed("A|B Ltd", `Dev \ Ops`, "Build"),
+		`Acme | Dev | Fix a \| b`:       parsed("Acme", "Dev", "Fix a | b"),
+	} {
+		task, ok := p.Parse(subject)
+		assert.True(t, ok, subject)
+		assert.Equal(t, want, task, subject)
+	}
+	for _, subject := range []string{"Lunch", `Lunch \| Break`, " | Planning"} {
+		_, ok := p.Parse(subject)
+		assert.False(t, ok, subject)
+	}
+
+	_, err = NewSubjectSeparator(" ", 0)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+	_, err = ParseSubject(`(?P<project>\w+)-(?P<desc>.*)`, ":", 0)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
+
+// Applies the subject parser to the events of every source.
+func Test_subject_parser_applies_to_sources(t *testing.T) {
+	p, _ := NewSubjectSeparator(":", 0)
+	path := writeIcs(t, "work.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:Acme-UK: Dev: Release 1.2
+DTSTART:20231101T100000Z
+DTEND:20231101T113000Z
+END:VEVENT`)
+	tasks := readIcsTasks(t, TsConfig{TimeZone: "UTC", Subject: p}, path)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "Acme-UK", tasks[0].Project)
+	assert.Equal(t, "Dev", tasks[0].Group)
+
+	path = writeFile(t, "log.csv", "subject,start,duration\nAcme-UK: Support,2023-11-03 09:00,1h\nLunch,2023-11-03 12:00,1h\n")
+	_, err := readImported(t, TsConfig{TimeZone: "UTC", Subject: p, ImportFiles: []string{path}})
+	assert.ErrorContains(t, err, "log.csv:3: subject 'Lunch' does not name a project")
+
+	path = writeFile(t, "log.json", `[{"Subject": "Acme-UK: Support", "Start": "2023-11-03 09:00", "Duration": "1h"}]`)
+	tasks, err = readImported(t, TsConfig{TimeZone: "UTC", Subject: p, ImportFiles: []string{path}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Acme-UK", "", "Support"}, []string{tasks[0].Project, tasks[0].Group, tasks[0].Desc})
+
+	fg := newFakeGraph(t, []map[string]any{graphEvent("Acme-UK: Dev: Release", novFrom.Add(9*hr), novFrom.Add(10*hr))})
+	tasks, err = newTestGraphSvc(t, fg.URL, TsConfig{TimeZone: "UTC", Subject: p}).Read(context.Background(), testUser, novFrom, novTo)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "Acme-UK", tasks[0].Project)
+}
+
+// Splits subjects into more levels than project, group and description.
+func Test_subject_levels(t *testing.T) {
+	p, err := ParseSubject("", "", 4)
+	assert.NoError(t, err)
+	task, ok := p.Parse("Acme - Website - Design - Home page mockups")
+	assert.True(t, ok)
+	assert.Equal(t, domain.Task{Project: "Acme", Group: "Website", Subgroups: []string{"Design"}, Desc: "Home page mockups"}, task)
+	task, ok = p.Parse("Acme - Website - Planning")
+	assert.True(t, ok)
+	assert.Equal(t, parsed("Acme", "Website", "Planning"), task)
+
+	p, err = ParseSubject("", ">", 5)
+	assert.NoError(t, err)
+	task, ok = p.Parse("Acme Corp > Website > Design > Review > Home page > mockups")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Website", "Design", "Review", "Home page > mockups"}, task.Path())
+	task, ok = p.Parse("Acme Corp > Support")
+	assert.True(t, ok)
+	assert.Equal(t, parsed("Acme Corp", "", "Support"), task)
+
+	p, err = ParseSubject(`^(?P<project>\w+)/(?P<group>\w+)/(?P<group2>\w+)/(?P<group3>\w+): (?P<desc>.*)$`, "", 0)
+	assert.NoError(t, err)
+	task, ok = p.Parse("Acme/Web/Design/Review: mockups")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Web", "Design", "Review", "mockups"}, task.Path())
+
+	_, err = ParseSubject("", "", 1)
+	assert.ErrorIs(t, err, domain.ErrConfig)
+}
+
+// Reports a deeper hierarchy as a tree with a subtotal at every level.
+func Test_report_nested_levels(t *testing.T) {
+	p, _ := NewSubjectSeparator(">", 4)
+	path := writeIcs(t, "work.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:Acme > Website > Design > Mockups
+DTSTART:20231101T100000Z
+DTEND:20231101T113000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:2
+SUMMARY:Acme > Website > Build > Home page
+DTSTART:20231102T090000Z
+DTEND:20231102T100000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:3
+SUMMARY:Acme > Website > Design > Review
+DTSTART:20231103T090000Z
+DTEND:20231103T093000Z
+END:VEVENT`)
+	cfg := TsConfig{IcsFiles: []string{path}, TimeZone: "UTC", Subject: p, DateFrom: novFrom, DateTo: novTo}
+	assert.Contains(t, runReport(t, cfg), `
+- Website (3 hr)
+  - Design (2 hr)
+    - Mockups (1 hr 30 min)
+    - Review (30 min)
+  - Build (1 hr)
+    - Home page (1 hr)
+`)
+}
+
+// Reports hyphenated groups and descriptions that share a prefix as separate tasks.
+func Test_report_hyphenated_names(t *testing.T) {
+	p, _ := NewSubjectSeparator("|", 0)
+	path := writeIcs(t, "work.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:Acme | A | B-C
+DTSTART:20231101T100000Z
+DTEND:20231101T110000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:2
+SUMMARY:Acme | A-B | C
+DTSTART:20231101T100000Z
+DTEND:20231101T103000Z
+END:VEVENT`)
+	cfg := TsConfig{IcsFiles: []string{path}, TimeZone: "UTC", Subject: p, DateFrom: novFrom, DateTo: novTo}
+	assert.Contains(t, runReport(t, cfg), `
+- A (1 hr)
+- A-B (30 min)
+
+- A B-C (1 hr)
+- A-B C (30 min)
+`)
+}
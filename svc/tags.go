@@ -0,0 +1,81 @@
+package svc
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+// An annotation at the end of a description: "#name" or "#name=value".
+// A name starts with a letter so that an issue number such as "#42" stays
+// part of the description.
+var tagPattern = regexp.MustCompile(`(?:^|\s+)#([A-Za-z][\w-]*)(?:=(\S+))?\s*$`)
+
+// Remove the annotations from the end of a description, such as
+// "fix login #billable #ticket=JIRA-123", and return them by tag name.
+// Tag names are not case sensitive and are returned in lower case.
+// The tags are nil if there are none.
+func splitTags(desc string) (string, map[string]string) {
+	var tags map[string]string
+	for {
+		m := tagPattern.FindStringSubmatchIndex(desc)
+		if m == nil {
+			return desc, tags
+		}
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		name := strings.ToLower(desc[m[2]:m[3]])
+		if _, ok := tags[name]; !ok { // The last of repeated tags is the one written first.
+			tags[name] = ""
+			if m[4] >= 0 {
+				tags[name] = desc[m[4]:m[5]]
+			}
+		}
+		desc = desc[:m[0]]
+	}
+}
+
+// Parse a comma separated list of tags, each "name" or "name=value" with an
+// optional leading "#". Tag names are returned in lower case.
+func ParseTags(s string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		if tag == "" {
+			continue
+		}
+		name, value, hasValue := strings.Cut(tag, "=")
+		tag = strings.ToLower(name)
+		if hasValue {
+			tag += "=" + value
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Return the tasks that have every one of the tags.
+// Unclassified tasks have no tags and are always kept so that they are
+// still reported, and counted against the unclassified limit.
+func filterTags(tasks []domain.Task, tags []string) []domain.Task {
+	if len(tags) == 0 {
+		return tasks
+	}
+	filtered := []domain.Task{}
+	for _, task := range tasks {
+		ok := true
+		if task.Unclassified {
+			filtered = append(filtered, task)
+			continue
+		}
+		for _, tag := range tags {
+			ok = ok && task.HasTag(tag)
+		}
+		if ok {
+			filtered = append(filtered, task)
+		}
+	}
+	return filtered
+}
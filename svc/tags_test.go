@@ -0,0 +1,96 @@
+package svc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+func Test_split_tags(t *testing.T) {
+	desc, tags := splitTags("fix login #billable #Ticket=JIRA-123")
+	assert.Equal(t, "fix login", desc)
+	assert.Equal(t, map[string]string{"billable": "", "ticket": "JIRA-123"}, tags)
+
+	// Only trailing annotations are tags.
+	desc, tags = splitTags("review #42 comments")
+	assert.Equal(t, "review #42 comments", desc)
+	assert.Nil(t, tags)
+	desc, tags = splitTags("fix bug #42")
+	assert.Equal(t, "fix bug #42", desc)
+	assert.Nil(t, tags)
+	desc, tags = splitTags("fix bug #42 #billable")
+	assert.Equal(t, "fix bug #42", desc)
+	assert.Equal(t, map[string]string{"billable": ""}, tags)
+	desc, tags = splitTags("a=b c#d")
+	assert.Equal(t, "a=b c#d", desc)
+	assert.Nil(t, tags)
+}
+
+// Removes the tags from the descriptions of parsed subjects.
+func Test_subject_tags(t *testing.T) {
+	var p SubjectParser
+	task, ok := p.Parse("ACME - Dev - fix login #billable #ticket=JIRA-123")
+	assert.True(t, ok)
+	assert.Equal(t, "fix login", task.Desc)
+	assert.True(t, task.HasTag("billable"))
+	assert.True(t, task.HasTag("#ticket=JIRA-123"))
+	assert.True(t, task.HasTag("Ticket"))
+	assert.False(t, task.HasTag("ticket=JIRA-124"))
+	assert.False(t, task.HasTag("internal"))
+}
+
+func Test_parse_tags(t *testing.T) {
+	assert.Equal(t, []string{"billable", "ticket=JIRA-123"}, ParseTags(" #Billable, Ticket=JIRA-123,"))
+	assert.Equal(t, []string{}, ParseTags(""))
+}
+
+func Test_filter_tags(t *testing.T) {
+	tasks := []domain.Task{
+		{Desc: "a", Tags: map[string]string{"billable": "", "ticket": "JIRA-1"}},
+		{Desc: "b", Tags: map[string]string{"billable": ""}},
+		{Desc: "c"},
+		{Desc: "d", Unclassified: true},
+	}
+	assert.Equal(t, 4, len(filterTags(tasks, nil)))
+	assert.Equal(t, 3, len(filterTags(tasks, []string{"billable"})))
+	assert.Equal(t, "a", filterTags(tasks, []string{"billable", "ticket=JIRA-1"})[0].Desc)
+}
+
+// Filters the report by tag and subtotals the time of each tag.
+func Test_report_tags(t *testing.T) {
+	path := writeIcs(t, "work.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:ACME - Dev - fix login #billable #ticket=JIRA-123
+DTSTART:20231101T100000Z
+DTEND:20231101T113000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:2
+SUMMARY:ACME - Dev - fix login #ticket=JIRA-124
+DTSTART:20231102T090000Z
+DTEND:20231102T100000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:3
+SUMMARY:ACME - Dev - standup #billable
+DTSTART:20231103T090000Z
+DTEND:20231103T091500Z
+END:VEVENT`)
+	cfg := TsConfig{IcsFiles: []string{path}, TimeZone: "UTC", DateFrom: novFrom, DateTo: novTo, SubtotalTags: []string{"billable", "ticket"}}
+	assert.Contains(t, runReport(t, cfg), `
+- Dev fix login (2 hr 30 min)
+- Dev standup (15 min)
+
+- #billable (1 hr 45 min)
+- #ticket=JIRA-123 (1 hr 30 min)
+- #ticket=JIRA-124 (1 hr)
+`)
+
+	cfg.Tags = []string{"billable"}
+	cfg.SubtotalTags = nil
+	report := runReport(t, cfg)
+	assert.Contains(t, report, "ACME = 1 hr 45 min\n")
+	assert.NotContains(t, report, "#")
+}
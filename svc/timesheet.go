@@ -1,7 +1,10 @@
 package svc
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
 
@@ -9,32 +12,149 @@ import (
 )
 
 type TsConfig struct {
-	UserName string
-	DateFrom time.Time
-	DateTo   time.Time
-	Auth     domain.Auth
+	UserName           string
+	DateFrom           time.Time
+	DateTo             time.Time
+	TimeZone           string // Reporting time zone (IANA or Windows name). Defaults to the mailbox time zone.
+	Auth               domain.Auth
+	Retry              RetryConfig       // How throttled Graph requests are retried.
+	Calendars          []CalendarRef     // The calendars to read. The user's default calendar if empty.
+	Exclude            []string          // The rules that exclude events, such as ExcludeDeclined.
+	Subject            SubjectParser     // How event subjects are split into project, group and description.
+	Categories         CategoryMap       // The project and group of the events in each Outlook category.
+	Aliases            Aliases           // How the names of projects and groups are normalised.
+	CategoryPrecedence string            // Whether the subject or the category classifies an event that both could, such as PrecedenceSubject.
+	AllDay             time.Duration     // The time worked on each working day of an all-day event. All-day events are ignored if zero.
+	Holidays           []time.Time       // Dates that are not working days.
+	CacheDir           string            // The directory in which fetched events are cached. No cache is used if empty.
+	Record             string            // A fixture file in which to record the events read from Graph.
+	Replay             string            // A fixture file from which to replay events instead of reading from Graph.
+	Sources            []string          // The sources of events, such as SourceGraph. Chosen from the other settings if empty.
+	Duplicates         string            // How tasks found in more than one source are resolved, such as DuplicatesFirst.
+	IcsFiles           []string          // iCalendar files from which to read events instead of from Graph.
+	CalDAV             CalDAVConfig      // CalDAV calendars from which to read events instead of from Graph.
+	ImportFiles        []string          // CSV and JSON files of tasks to add to those of the source.
+	CsvColumns         map[string]string // The CSV header of each task field, such as ColumnProject, that is not named after the field.
+	Tags               []string          // Only tasks with every one of these tags, such as "billable" or "ticket=JIRA-123", are reported.
+	SubtotalTags       []string          // The names of the tags whose time is subtotalled within each project.
+	MaxUnclassified    time.Duration     // Fail if more time than this is spent in unclassified events. There is no limit if zero.
+	Verbose            bool              // Report progress information on stderr.
+}
+
+// A CalendarRef identifies a calendar by name or id, optionally within a mailbox
+// other than the user's own. It is written as "[mailbox:]calendar" where the
+// mailbox is a user principal name and an empty calendar, or "default", is the
+// mailbox's default calendar. So "Timesheet", "team@oldgang.net" and
+// "team@oldgang.net:Billable" are all valid references.
+type CalendarRef struct {
+	Mailbox  string
+	Calendar string
+}
+
+func ParseCalendarRef(s string) CalendarRef {
+	s = strings.TrimSpace(s)
+	if mailbox, calendar, found := strings.Cut(s, ":"); found && strings.Contains(mailbox, "@") {
+		return CalendarRef{Mailbox: mailbox, Calendar: calendar}
+	}
+	if strings.Contains(s, "@") {
+		return CalendarRef{Mailbox: s}
+	}
+	return CalendarRef{Calendar: s}
+}
+
+// Parse a comma separated list of calendar references.
+func ParseCalendarRefs(s string) []CalendarRef {
+	refs := []CalendarRef{}
+	for _, r := range strings.Split(s, ",") {
+		if strings.TrimSpace(r) != "" {
+			refs = append(refs, ParseCalendarRef(r))
+		}
+	}
+	return refs
+}
+
+func (ref CalendarRef) IsDefault() bool {
+	return ref.Calendar == "" || strings.EqualFold(ref.Calendar, "default")
+}
+
+func (ref CalendarRef) String() string {
+	calendar := ref.Calendar
+	if ref.IsDefault() {
+		calendar = "default"
+	}
+	if ref.Mailbox == "" {
+		return calendar
+	}
+	return ref.Mailbox + ":" + calendar
 }
 
 // tsSvc implements domain.TimesheetSvc.
 type tsSvc struct {
 	domain.TimesheetServices
 	cfg TsConfig
+	out io.Writer // Where the report is written.
 }
 
 func NewTimesheetSvc(cfg TsConfig, svc domain.TimesheetServices) domain.TimesheetSvc {
 	return tsSvc{
 		cfg:               cfg,
 		TimesheetServices: svc,
+		out:               os.Stdout,
 	}
 }
 
-func (svc tsSvc) Run() error {
-	tasks, err := svc.Graph.Read(svc.cfg.UserName, svc.cfg.DateFrom, svc.cfg.DateTo)
+// Clip the tasks to the days of the report, from the start of the day
+// fromDate to the end of the day toDate, and split those that cross midnight
+// into a task for each day so that their time is counted on the days, and in
+// the weeks, in which it was spent. Unclassified tasks are clipped but not
+// split so that each is reported once.
+func reportTasks(tasks []domain.Task, fromDate time.Time, toDate time.Time) []domain.Task {
+	clipped := []domain.Task{}
+	for _, task := range tasks {
+		loc := task.Start.Location()
+		from := dateOf(inZone(fromDate, loc), loc)
+		to := dateOf(inZone(toDate, loc), loc).AddDate(0, 0, 1)
+		task, ok := task.Clip(from, to)
+		switch {
+		case !ok:
+		case task.Unclassified:
+			clipped = append(clipped, task)
+		default:
+			clipped = append(clipped, task.SplitDays()...)
+		}
+	}
+	return clipped
+}
+
+func (svc tsSvc) Run(ctx context.Context) error {
+	tasks, err := svc.Graph.Read(ctx, svc.cfg.UserName, svc.cfg.DateFrom, svc.cfg.DateTo)
 	if err != nil {
 		return err
 	}
+	if s, ok := svc.Graph.(interface{ Stats() GraphStats }); ok && svc.cfg.Verbose {
+		stats := s.Stats()
+		fmt.Fprintf(os.Stderr, "Fetched %d events in %d pages; excluded %d; %d duplicates.\n", stats.Events, stats.Pages, stats.Excluded, stats.Duplicates)
+	}
+	tasks, rewrites := svc.cfg.Aliases.Apply(tasks)
+	for _, r := range rewrites {
+		fmt.Fprintf(os.Stderr, "Renamed %s '%s' to '%s' in %d tasks.\n", r.Level, r.From, r.To, r.Tasks)
+	}
+	tasks = reportTasks(tasks, svc.cfg.DateFrom, svc.cfg.DateTo)
+	tasks = filterTags(tasks, svc.cfg.Tags)
 	projects := svc.Cal.Aggregate(tasks)
-	lines := svc.Dump.Projects(projects)
-	fmt.Println(strings.Join(lines, "\n"))
+	unclassified := []domain.Task{}
+	var unclassifiedTime time.Duration
+	for _, task := range tasks {
+		if task.Unclassified {
+			unclassified = append(unclassified, task)
+			unclassifiedTime += task.Duration
+		}
+	}
+	lines := svc.Dump.Projects(projects, unclassified)
+	fmt.Fprintln(svc.out, strings.Join(lines, "\n"))
+	if svc.cfg.MaxUnclassified > 0 && unclassifiedTime > svc.cfg.MaxUnclassified {
+		return fmt.Errorf("%w: %s spent in %d unclassified events exceeds the limit of %s", domain.ErrParse,
+			fmtLongTime(unclassifiedTime), len(unclassified), fmtLongTime(svc.cfg.MaxUnclassified))
+	}
 	return nil
 }
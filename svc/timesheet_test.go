@@ -0,0 +1,78 @@
+package svc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vextasy/Timesheet_go/domain"
+)
+
+func Test_task_clip_and_split(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	task := domain.Task{Project: "ProjectX", Start: time.Date(2023, 10, 31, 22, 0, 0, 0, london), Duration: 4 * hr}
+	assert.Equal(t, time.Date(2023, 11, 1, 2, 0, 0, 0, london), task.End())
+
+	clipped, ok := task.Clip(time.Date(2023, 11, 1, 0, 0, 0, 0, london), time.Date(2023, 12, 1, 0, 0, 0, 0, london))
+	assert.True(t, ok)
+	assert.Equal(t, "2023-11-01T00:00:00Z", clipped.Start.Format(time.RFC3339))
+	assert.Equal(t, 2*hr, clipped.Duration)
+	_, ok = task.Clip(time.Date(2023, 11, 2, 0, 0, 0, 0, london), time.Date(2023, 12, 1, 0, 0, 0, 0, london))
+	assert.False(t, ok)
+
+	parts := task.SplitDays()
+	assert.Equal(t, 2, len(parts))
+	assert.Equal(t, "2023-10-31T22:00:00Z", parts[0].Start.Format(time.RFC3339))
+	assert.Equal(t, 2*hr, parts[0].Duration)
+	assert.Equal(t, "2023-11-01T00:00:00Z", parts[1].Start.Format(time.RFC3339))
+	assert.Equal(t, 2*hr, parts[1].Duration)
+
+	// A task spanning the end of daylight saving time keeps its elapsed time.
+	task = domain.Task{Start: time.Date(2023, 10, 28, 23, 0, 0, 0, london), Duration: 27 * hr}
+	parts = task.SplitDays()
+	assert.Equal(t, []time.Duration{hr, 25 * hr, hr}, []time.Duration{parts[0].Duration, parts[1].Duration, parts[2].Duration})
+
+	// A task within a day, or ending at midnight, is not split.
+	task = domain.Task{Start: time.Date(2023, 11, 1, 23, 0, 0, 0, london), Duration: hr}
+	assert.Equal(t, []domain.Task{task}, task.SplitDays())
+}
+
+// Clips tasks to the days of the report and splits those that cross midnight.
+func Test_report_tasks(t *testing.T) {
+	at := func(d int, h int) time.Time { return time.Date(2023, 11, d, h, 0, 0, 0, time.UTC) }
+	tasks := reportTasks([]domain.Task{
+		{Project: "ProjectX", Desc: "On call", Start: time.Date(2023, 10, 31, 22, 0, 0, 0, time.UTC), Duration: 4 * hr},
+		{Project: "ProjectX", Desc: "On call", Start: at(5, 22), Duration: 4 * hr},
+		{Project: "ProjectX", Desc: "Release", Start: at(30, 20), Duration: 6 * hr},
+		{Desc: "Party", Start: at(3, 22), Duration: 4 * hr, Unclassified: true},
+		{Project: "ProjectX", Desc: "Next month", Start: time.Date(2023, 12, 1, 9, 0, 0, 0, time.UTC), Duration: hr},
+	}, novFrom, novTo)
+	starts := []string{}
+	for _, task := range tasks {
+		starts = append(starts, task.Start.Format("02 15:04")+" "+fmtLongTime(task.Duration))
+	}
+	assert.Equal(t, []string{"01 00:00 2 hr", "05 22:00 2 hr", "06 00:00 2 hr", "30 20:00 4 hr", "03 22:00 4 hr"}, starts)
+}
+
+// Counts the time of an overnight task on each day and in each week.
+func Test_report_splits_overnight_tasks(t *testing.T) {
+	path := writeIcs(t, "work.ics", `
+BEGIN:VEVENT
+UID:1
+SUMMARY:ProjectX - OnCall - overnight
+DTSTART:20231105T220000Z
+DTEND:20231106T020000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:2
+SUMMARY:ProjectX - OnCall - overnight
+DTSTART:20231031T230000Z
+DTEND:20231101T010000Z
+END:VEVENT`)
+	cfg := TsConfig{IcsFiles: []string{path}, TimeZone: "UTC", DateFrom: novFrom, DateTo: novTo}
+	report := runReport(t, cfg)
+	assert.Contains(t, report, "ProjectX = 5 hr\n")
+	assert.Contains(t, report, "w/b 30/10/2023 - 0 + 0 + 1 + 0 + 0 + 0 + 2 = 3\n")
+	assert.Contains(t, report, "w/b 06/11/2023 - 2 + 0 + 0 + 0 + 0 + 0 + 0 = 2\n")
+	assert.Contains(t, report, "- OnCall overnight (5 hr)\n")
+}